@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"csye7255-project-one/config"
+	"csye7255-project-one/services"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var commands = map[string]func(args []string) error{
+	"reconcile": reconcileCommand,
+	"reindex":   reindexCommand,
+	"rebuild":   rebuildCommand,
+	"api-key":   apiKeyCommand,
+	"purge":     purgeCommand,
+}
+
+func runCommand(name string, args []string) error {
+	command, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return command(args)
+}
+
+// reconcileCommand compares Redis with Elasticsearch and prints the report as
+// JSON. It exits non-zero when drift was found and -repair was not given.
+func reconcileCommand(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "re-index drifted plans and delete orphaned documents")
+	flags.Parse(args)
+
+	store := setupStore()
+	config.SetupElasticsearch()
+
+	report, err := services.Reconcile(store, indexName, *repair)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if len(report.Drifts) > 0 && !*repair {
+		return errors.New("drift detected")
+	}
+	return nil
+}
+
+// reindexCommand rebuilds the index from Redis into a new physical index and
+// moves the alias onto it without downtime. It is also how indices created
+// with an older mapping are migrated.
+func reindexCommand(args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	deleteOld := flags.Bool("delete-old", false, "delete the previous physical index after the swap")
+	ifOutdated := flags.Bool("if-outdated", false, "only reindex when the index mapping is outdated")
+	flags.Parse(args)
+
+	store := setupStore()
+	config.SetupElasticsearch()
+
+	if *ifOutdated {
+		outdated, err := services.MappingOutdated(indexName)
+		if err != nil {
+			return err
+		}
+		if !outdated {
+			fmt.Println("Index mapping is up to date")
+			return nil
+		}
+	}
+
+	report, err := services.Reindex(store, indexName, *deleteOld)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// rebuildCommand repopulates the index from Redis, e.g. after the cluster was
+// wiped, and prints the final progress as JSON.
+func rebuildCommand(args []string) error {
+	flags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	workers := flags.Int("workers", 4, "number of plans indexed concurrently")
+	resume := flags.Bool("resume", false, "continue from the checkpoint of an interrupted rebuild")
+	flags.Parse(args)
+
+	store := setupStore()
+	config.SetupElasticsearch()
+
+	progress, err := services.Rebuild(context.Background(), store, indexName, services.RebuildOptions{
+		Workers: *workers,
+		Resume:  *resume,
+		Progress: func(p services.RebuildProgress) {
+			log.Printf("Rebuilt %d of %d plans, %d failed", p.Indexed, p.Total, len(p.Failed))
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(progress); err != nil {
+		return err
+	}
+
+	if len(progress.Failed) > 0 {
+		return fmt.Errorf("%d plans failed to index", len(progress.Failed))
+	}
+	return nil
+}
+
+// apiKeyCommand creates an API key without going through the admin API, e.g.
+// to bootstrap the first admin key, and prints the plaintext key once.
+func apiKeyCommand(args []string) error {
+	flags := flag.NewFlagSet("api-key", flag.ExitOnError)
+	name := flags.String("name", "", "name of the key")
+	scopes := flags.String("scopes", "", "comma-separated permissions, e.g. plans:read,plans:write")
+	orgs := flags.String("orgs", "", "comma-separated orgs the key is bound to, or *")
+	ttl := flags.Duration("ttl", 0, "lifetime of the key; 0 never expires")
+	flags.Parse(args)
+
+	// A key created in an in-memory store would vanish with this process
+	if os.Getenv("STORE_BACKEND") == "memory" {
+		return errors.New("api-key needs the Redis store; unset STORE_BACKEND=memory")
+	}
+	config.SetupRedis()
+
+	var expiresAt *time.Time
+	if *ttl > 0 {
+		expires := time.Now().Add(*ttl).UTC()
+		expiresAt = &expires
+	}
+
+	key, plaintext, err := services.CreateAPIKey(services.NewRedisAPIKeyStore(config.RedisClient, apiKeysKey), *name, splitList(*scopes), splitList(*orgs), expiresAt)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{"apiKey": key.Public(), "key": plaintext})
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// purgeCommand permanently removes the plans deleted longer than
+// DELETE_RETENTION ago, which can then no longer be restored.
+func purgeCommand(args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	flags.Parse(args)
+
+	store := setupStore()
+	purged, err := services.PurgeExpired(store)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Purged %d deleted plans\n", purged)
+	return nil
+}
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	PermissionPlansRead   = "plans:read"
+	PermissionPlansWrite  = "plans:write"
+	PermissionPlansDelete = "plans:delete"
+	PermissionAdmin       = "admin"
+
+	// AllOrgs bound to a caller grants access to the plans of every org.
+	AllOrgs = "*"
+)
+
+var (
+	knownPermissions = map[string]bool{
+		PermissionPlansRead:   true,
+		PermissionPlansWrite:  true,
+		PermissionPlansDelete: true,
+		PermissionAdmin:       true,
+	}
+
+	// defaultRoles are available in every policy and may be redefined by it.
+	defaultRoles = map[string][]string{
+		"reader": {PermissionPlansRead},
+		"editor": {PermissionPlansRead, PermissionPlansWrite, PermissionPlansDelete},
+		"admin":  {PermissionPlansRead, PermissionPlansWrite, PermissionPlansDelete, PermissionAdmin},
+	}
+
+	Authorization *AuthorizationPolicy
+)
+
+// AuthorizationPolicy maps token claims to roles and roles to permissions,
+// and binds callers to the orgs whose plans they may access. Every caller gets
+// DefaultRoles and DefaultOrgs plus the roles and orgs of each binding
+// matching its claims. OrgClaim names a claim holding the caller's org or
+// orgs; OrgFromEmailDomain binds the domain of a verified email.
+type AuthorizationPolicy struct {
+	Roles              map[string][]string `json:"roles"`
+	Bindings           []RoleBinding       `json:"bindings"`
+	DefaultRoles       []string            `json:"defaultRoles"`
+	DefaultOrgs        []string            `json:"defaultOrgs"`
+	OrgClaim           string              `json:"orgClaim"`
+	OrgFromEmailDomain bool                `json:"orgFromEmailDomain"`
+}
+
+// RoleBinding grants Roles and Orgs to callers whose Claim equals Value or,
+// for list claims such as groups, contains it. With EmailDomain set it matches
+// a verified email claim in that domain instead.
+type RoleBinding struct {
+	Claim       string   `json:"claim,omitempty"`
+	Value       string   `json:"value,omitempty"`
+	EmailDomain string   `json:"emailDomain,omitempty"`
+	Roles       []string `json:"roles"`
+	Orgs        []string `json:"orgs,omitempty"`
+}
+
+// LoadAuthorizationPolicy reads the policy from AUTHZ_POLICY, a JSON
+// AuthorizationPolicy, or from the file named by AUTHZ_POLICY_FILE. Without
+// either, callers are granted no roles and bound to no orgs, so every route
+// that requires a permission is refused to them.
+func LoadAuthorizationPolicy() (*AuthorizationPolicy, error) {
+	data := []byte(os.Getenv("AUTHZ_POLICY"))
+	if path := os.Getenv("AUTHZ_POLICY_FILE"); len(data) == 0 && path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AUTHZ_POLICY_FILE: %v", err)
+		}
+		data = fileData
+	}
+
+	policy := &AuthorizationPolicy{}
+	if len(data) == 0 {
+		log.Printf("No authorization policy configured; set AUTHZ_POLICY or AUTHZ_POLICY_FILE to grant callers roles and orgs")
+	} else if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse authorization policy: %v", err)
+	}
+
+	roles := make(map[string][]string, len(defaultRoles)+len(policy.Roles))
+	for role, permissions := range defaultRoles {
+		roles[role] = permissions
+	}
+	for role, permissions := range policy.Roles {
+		for _, permission := range permissions {
+			if !knownPermissions[permission] {
+				return nil, fmt.Errorf("role %s has unknown permission %s", role, permission)
+			}
+		}
+		roles[role] = permissions
+	}
+	policy.Roles = roles
+
+	granted := append([]string{}, policy.DefaultRoles...)
+	for _, binding := range policy.Bindings {
+		if binding.EmailDomain == "" && (binding.Claim == "" || binding.Value == "") {
+			return nil, fmt.Errorf("role binding needs an emailDomain or a claim and value")
+		}
+		if len(binding.Roles) == 0 && len(binding.Orgs) == 0 {
+			return nil, fmt.Errorf("role binding needs roles or orgs")
+		}
+		granted = append(granted, binding.Roles...)
+	}
+	for _, role := range granted {
+		if _, ok := roles[role]; !ok {
+			return nil, fmt.Errorf("unknown role %s", role)
+		}
+	}
+	return policy, nil
+}
+
+func InitAuthorization() {
+	policy, err := LoadAuthorizationPolicy()
+	if err != nil {
+		log.Fatalf("Invalid authorization policy: %v", err)
+	}
+	Authorization = policy
+}
+
+// IsPermission reports whether permission is one routes can require.
+func IsPermission(permission string) bool {
+	return knownPermissions[permission]
+}
+
+// RolesFor returns the sorted roles granted to a caller with claims.
+func (p *AuthorizationPolicy) RolesFor(claims map[string]interface{}) []string {
+	granted := map[string]bool{}
+	for _, role := range p.DefaultRoles {
+		granted[role] = true
+	}
+	for _, binding := range p.Bindings {
+		if binding.matches(claims) {
+			for _, role := range binding.Roles {
+				granted[role] = true
+			}
+		}
+	}
+
+	roles := make([]string, 0, len(granted))
+	for role := range granted {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// OrgsFor returns the sorted orgs a caller with claims is bound to.
+func (p *AuthorizationPolicy) OrgsFor(claims map[string]interface{}) []string {
+	bound := map[string]bool{}
+	for _, org := range p.DefaultOrgs {
+		bound[org] = true
+	}
+	for _, binding := range p.Bindings {
+		if binding.matches(claims) {
+			for _, org := range binding.Orgs {
+				bound[org] = true
+			}
+		}
+	}
+
+	if p.OrgClaim != "" {
+		switch value := claims[p.OrgClaim].(type) {
+		case string:
+			bound[value] = true
+		case []interface{}:
+			for _, item := range value {
+				if org, ok := item.(string); ok {
+					bound[org] = true
+				}
+			}
+		}
+	}
+	if p.OrgFromEmailDomain {
+		if domain := verifiedEmailDomain(claims, "email"); domain != "" {
+			bound[domain] = true
+		}
+	}
+	delete(bound, "")
+
+	orgs := make([]string, 0, len(bound))
+	for org := range bound {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	return orgs
+}
+
+// Permits reports whether any of roles grants permission.
+func (p *AuthorizationPolicy) Permits(roles []string, permission string) bool {
+	for _, role := range roles {
+		for _, granted := range p.Roles[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (b RoleBinding) matches(claims map[string]interface{}) bool {
+	if b.EmailDomain != "" {
+		claim := b.Claim
+		if claim == "" {
+			claim = "email"
+		}
+		return verifiedEmailDomain(claims, claim) == strings.ToLower(b.EmailDomain)
+	}
+
+	switch value := claims[b.Claim].(type) {
+	case string:
+		return value == b.Value
+	case []interface{}:
+		for _, item := range value {
+			if item == b.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifiedEmailDomain returns the lower-cased domain of the email in claim, or
+// "" when there is none or email_verified is not true. Unverified addresses
+// prove nothing about the domain.
+func verifiedEmailDomain(claims map[string]interface{}, claim string) string {
+	if claims["email_verified"] != true {
+		return ""
+	}
+	email, _ := claims[claim].(string)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
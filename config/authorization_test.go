@@ -0,0 +1,188 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVerifiedEmailDomain(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   string
+	}{
+		{"verified", map[string]interface{}{"email": "Ann@Example.COM", "email_verified": true}, "example.com"},
+		{"unverified", map[string]interface{}{"email": "ann@example.com", "email_verified": false}, ""},
+		{"verification missing", map[string]interface{}{"email": "ann@example.com"}, ""},
+		{"verification not a bool", map[string]interface{}{"email": "ann@example.com", "email_verified": "true"}, ""},
+		{"no email", map[string]interface{}{"email_verified": true}, ""},
+		{"no domain", map[string]interface{}{"email": "ann", "email_verified": true}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifiedEmailDomain(tt.claims, "email"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+const testPolicy = `{
+	"roles": {"auditor": ["plans:read", "admin"]},
+	"bindings": [
+		{"claim": "groups", "value": "plan-editors", "roles": ["editor"]},
+		{"claim": "sub", "value": "ops", "roles": ["admin"], "orgs": ["*"]},
+		{"emailDomain": "Example.com", "roles": ["reader"], "orgs": ["example.com"]},
+		{"claim": "tenant", "value": "acme", "orgs": ["acme", "acme-labs"]}
+	],
+	"defaultRoles": ["reader"],
+	"orgClaim": "org",
+	"orgFromEmailDomain": true
+}`
+
+func loadTestPolicy(t *testing.T, policy string) *AuthorizationPolicy {
+	t.Helper()
+	t.Setenv("AUTHZ_POLICY", policy)
+	t.Setenv("AUTHZ_POLICY_FILE", "")
+	loaded, err := LoadAuthorizationPolicy()
+	if err != nil {
+		t.Fatalf("failed to load policy: %v", err)
+	}
+	return loaded
+}
+
+func TestLoadAuthorizationPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{name: "no policy"},
+		{name: "policy", policy: testPolicy},
+		{name: "not JSON", policy: "editor", wantErr: true},
+		{name: "unknown permission", policy: `{"roles": {"owner": ["plans:own"]}}`, wantErr: true},
+		{name: "unknown default role", policy: `{"defaultRoles": ["owner"]}`, wantErr: true},
+		{name: "unknown bound role", policy: `{"bindings": [{"claim": "sub", "value": "x", "roles": ["owner"]}]}`, wantErr: true},
+		{name: "binding without a match", policy: `{"bindings": [{"claim": "sub", "roles": ["reader"]}]}`, wantErr: true},
+		{name: "binding without a grant", policy: `{"bindings": [{"claim": "sub", "value": "x"}]}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AUTHZ_POLICY", tt.policy)
+			t.Setenv("AUTHZ_POLICY_FILE", "")
+			_, err := LoadAuthorizationPolicy()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRolesAndOrgsFor(t *testing.T) {
+	policy := loadTestPolicy(t, testPolicy)
+	noPolicy := loadTestPolicy(t, "")
+
+	tests := []struct {
+		name      string
+		policy    *AuthorizationPolicy
+		claims    map[string]interface{}
+		wantRoles []string
+		wantOrgs  []string
+	}{
+		{
+			name:      "no policy grants nothing",
+			policy:    noPolicy,
+			claims:    map[string]interface{}{"sub": "ops", "email": "ann@example.com", "email_verified": true},
+			wantRoles: []string{},
+			wantOrgs:  []string{},
+		},
+		{
+			name:      "defaults only",
+			policy:    policy,
+			claims:    map[string]interface{}{"sub": "ann"},
+			wantRoles: []string{"reader"},
+			wantOrgs:  []string{},
+		},
+		{
+			name:      "list claim",
+			policy:    policy,
+			claims:    map[string]interface{}{"groups": []interface{}{"staff", "plan-editors"}},
+			wantRoles: []string{"editor", "reader"},
+			wantOrgs:  []string{},
+		},
+		{
+			name:      "all orgs",
+			policy:    policy,
+			claims:    map[string]interface{}{"sub": "ops"},
+			wantRoles: []string{"admin", "reader"},
+			wantOrgs:  []string{AllOrgs},
+		},
+		{
+			name:      "verified email domain",
+			policy:    policy,
+			claims:    map[string]interface{}{"email": "ann@EXAMPLE.com", "email_verified": true},
+			wantRoles: []string{"reader"},
+			wantOrgs:  []string{"example.com"},
+		},
+		{
+			name:      "unverified email domain",
+			policy:    policy,
+			claims:    map[string]interface{}{"email": "ann@example.com"},
+			wantRoles: []string{"reader"},
+			wantOrgs:  []string{},
+		},
+		{
+			name:      "orgs from bindings and org claim",
+			policy:    policy,
+			claims:    map[string]interface{}{"tenant": "acme", "org": []interface{}{"beta", "", 7}},
+			wantRoles: []string{"reader"},
+			wantOrgs:  []string{"acme", "acme-labs", "beta"},
+		},
+		{
+			name:      "single org claim",
+			policy:    policy,
+			claims:    map[string]interface{}{"org": "beta"},
+			wantRoles: []string{"reader"},
+			wantOrgs:  []string{"beta"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.RolesFor(tt.claims); !reflect.DeepEqual(got, tt.wantRoles) {
+				t.Errorf("RolesFor = %v, want %v", got, tt.wantRoles)
+			}
+			if got := tt.policy.OrgsFor(tt.claims); !reflect.DeepEqual(got, tt.wantOrgs) {
+				t.Errorf("OrgsFor = %v, want %v", got, tt.wantOrgs)
+			}
+		})
+	}
+}
+
+func TestPermits(t *testing.T) {
+	policy := loadTestPolicy(t, testPolicy)
+
+	tests := []struct {
+		roles      []string
+		permission string
+		want       bool
+	}{
+		{nil, PermissionPlansRead, false},
+		{[]string{"reader"}, PermissionPlansRead, true},
+		{[]string{"reader"}, PermissionPlansWrite, false},
+		{[]string{"editor"}, PermissionPlansDelete, true},
+		{[]string{"editor"}, PermissionAdmin, false},
+		{[]string{"reader", "admin"}, PermissionAdmin, true},
+		{[]string{"auditor"}, PermissionAdmin, true},
+		{[]string{"auditor"}, PermissionPlansWrite, false},
+		{[]string{"unknown"}, PermissionPlansRead, false},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Permits(tt.roles, tt.permission); got != tt.want {
+			t.Errorf("Permits(%v, %s) = %v, want %v", tt.roles, tt.permission, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package config
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	googleIssuer     = "https://accounts.google.com"
+	jwksFetchTimeout = 10 * time.Second
+
+	// Key sets are refreshed when their Cache-Control max-age runs out, within
+	// these bounds, and every defaultJWKSMaxAge when they have none.
+	defaultJWKSMaxAge = time.Hour
+	minJWKSMaxAge     = time.Minute
+	maxJWKSMaxAge     = 24 * time.Hour
+	jwksRetryInterval = 30 * time.Second
+
+	// An unknown kid triggers a refresh at most this often per issuer, so
+	// tokens with made-up key IDs cannot hammer the IdP.
+	unknownKidRefetchInterval = time.Minute
+)
+
+var (
+	issuers   map[string]*Issuer
+	issuersMu sync.RWMutex
+
+	// supportedAlgorithms are the signing algorithms accepted from any issuer.
+	supportedAlgorithms = []string{"RS256", "ES256"}
+
+	jwksHTTPClient = &http.Client{Timeout: jwksFetchTimeout}
+)
+
+// IssuerConfig describes a trusted token issuer. Its signing keys come from
+// JWKSFile, JWKSURL or, when neither is set, the jwks_uri found through OIDC
+// discovery at Issuer. Tokens must carry one of Audiences.
+type IssuerConfig struct {
+	Issuer    string   `json:"issuer"`
+	JWKSURL   string   `json:"jwksUrl,omitempty"`
+	JWKSFile  string   `json:"jwksFile,omitempty"`
+	Audiences []string `json:"audiences"`
+}
+
+// Issuer is a trusted issuer and its current signing keys by key ID.
+type Issuer struct {
+	IssuerConfig
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	expiresAt time.Time
+
+	refetchMu   sync.Mutex
+	lastRefetch time.Time
+}
+
+// LoadIssuerConfigs reads the trusted issuers from JWT_ISSUERS, a JSON array
+// of IssuerConfig, or from the file named by JWT_ISSUERS_FILE. Without either,
+// Google is trusted for GOOGLE_CLIENT_ID as before.
+func LoadIssuerConfigs() ([]IssuerConfig, error) {
+	data := []byte(os.Getenv("JWT_ISSUERS"))
+	if path := os.Getenv("JWT_ISSUERS_FILE"); len(data) == 0 && path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT_ISSUERS_FILE: %v", err)
+		}
+		data = fileData
+	}
+
+	if len(data) == 0 {
+		audiences := []string{os.Getenv("GOOGLE_CLIENT_ID")}
+		return []IssuerConfig{
+			{Issuer: googleIssuer, JWKSURL: googleCertsURL, Audiences: audiences},
+			// Google also issues tokens with the scheme left out
+			{Issuer: strings.TrimPrefix(googleIssuer, "https://"), JWKSURL: googleCertsURL, Audiences: audiences},
+		}, nil
+	}
+
+	var configs []IssuerConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse JWT issuers: %v", err)
+	}
+	for _, cfg := range configs {
+		if cfg.Issuer == "" {
+			return nil, errors.New("every JWT issuer needs an issuer")
+		}
+		if len(cfg.Audiences) == 0 {
+			return nil, fmt.Errorf("JWT issuer %s needs at least one audience", cfg.Issuer)
+		}
+	}
+	return configs, nil
+}
+
+// InitIssuers loads the trusted issuers and fetches their signing keys.
+// Issuers whose keys cannot be fetched are kept and retried by
+// RunJWKSRefresh.
+func InitIssuers() {
+	configs, err := LoadIssuerConfigs()
+	if err != nil {
+		log.Fatalf("Invalid JWT issuer configuration: %v", err)
+	}
+
+	loaded := make(map[string]*Issuer, len(configs))
+	for _, cfg := range configs {
+		issuer := &Issuer{IssuerConfig: cfg}
+		if err := issuer.Refresh(); err != nil {
+			fmt.Printf("Error fetching signing keys of %s: %v\n", cfg.Issuer, err)
+		}
+		loaded[cfg.Issuer] = issuer
+	}
+
+	issuersMu.Lock()
+	issuers = loaded
+	issuersMu.Unlock()
+}
+
+func getIssuer(iss string) (*Issuer, error) {
+	issuersMu.RLock()
+	defer issuersMu.RUnlock()
+
+	issuer, ok := issuers[iss]
+	if !ok {
+		return nil, fmt.Errorf("untrusted issuer: %s", iss)
+	}
+	return issuer, nil
+}
+
+// Refresh fetches the current signing keys of the issuer.
+func (i *Issuer) Refresh() error {
+	data, maxAge, err := i.fetchJWKS()
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	i.keys = keys
+	i.expiresAt = time.Now().Add(maxAge)
+	i.mu.Unlock()
+	return nil
+}
+
+// fetchJWKS returns the key set and how long it may be cached.
+func (i *Issuer) fetchJWKS() ([]byte, time.Duration, error) {
+	if i.JWKSFile != "" {
+		data, err := os.ReadFile(i.JWKSFile)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read JWKS file: %v", err)
+		}
+		return data, defaultJWKSMaxAge, nil
+	}
+
+	jwksURL := i.JWKSURL
+	if jwksURL == "" {
+		discovered, err := discoverJWKSURL(i.Issuer)
+		if err != nil {
+			return nil, 0, err
+		}
+		jwksURL = discovered
+	}
+
+	resp, err := jwksHTTPClient.Get(jwksURL)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch %s: %v", jwksURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to fetch %s: %s", jwksURL, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, cacheMaxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+// cacheMaxAge returns the max-age of a Cache-Control header clamped to the
+// allowed refresh interval, or defaultJWKSMaxAge without one.
+func cacheMaxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(strings.ToLower(directive)), "max-age=")
+		if !ok {
+			continue
+		}
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			break
+		}
+		maxAge := time.Duration(seconds) * time.Second
+		if maxAge < minJWKSMaxAge {
+			return minJWKSMaxAge
+		}
+		if maxAge > maxJWKSMaxAge {
+			return maxJWKSMaxAge
+		}
+		return maxAge
+	}
+	return defaultJWKSMaxAge
+}
+
+// RunJWKSRefresh starts refreshing the key set of every issuer in the
+// background whenever it expires, retrying failed fetches, until ctx is
+// cancelled.
+func RunJWKSRefresh(ctx context.Context) {
+	issuersMu.RLock()
+	defer issuersMu.RUnlock()
+
+	for _, issuer := range issuers {
+		go issuer.runRefresh(ctx)
+	}
+}
+
+func (i *Issuer) runRefresh(ctx context.Context) {
+	for {
+		i.mu.RLock()
+		wait := time.Until(i.expiresAt)
+		loaded := i.keys != nil
+		i.mu.RUnlock()
+		if !loaded {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if err := i.Refresh(); err != nil {
+			log.Printf("Failed to refresh signing keys of %s: %v", i.Issuer, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(jwksRetryInterval):
+			}
+		}
+	}
+}
+
+// IssuersReady reports whether the key set of at least one issuer is loaded.
+func IssuersReady() bool {
+	issuersMu.RLock()
+	defer issuersMu.RUnlock()
+
+	for _, issuer := range issuers {
+		issuer.mu.RLock()
+		loaded := len(issuer.keys) > 0
+		issuer.mu.RUnlock()
+		if loaded {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverJWKSURL reads jwks_uri from the OIDC discovery document of issuer.
+func discoverJWKSURL(issuer string) (string, error) {
+	data, err := httpGet(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(data, &discovery); err != nil {
+		return "", fmt.Errorf("failed to decode OIDC discovery document: %v", err)
+	}
+	if discovery.Issuer != issuer {
+		return "", fmt.Errorf("OIDC discovery document is for issuer %s", discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return "", errors.New("OIDC discovery document has no jwks_uri")
+	}
+	return discovery.JWKSURI, nil
+}
+
+func httpGet(url string) ([]byte, error) {
+	resp, err := jwksHTTPClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// key returns the signing key kid. A token without kid is accepted when the
+// issuer has a single key, as local test key sets often do. An unknown kid
+// usually means the keys were rotated, so the key set is fetched again, at
+// most once per unknownKidRefetchInterval.
+func (i *Issuer) key(kid string) (crypto.PublicKey, error) {
+	if key, ok := i.cachedKey(kid); ok {
+		return key, nil
+	}
+
+	i.refetchMu.Lock()
+	if time.Since(i.lastRefetch) >= unknownKidRefetchInterval {
+		i.lastRefetch = time.Now()
+		if err := i.Refresh(); err != nil {
+			log.Printf("Failed to refetch signing keys of %s for key ID %s: %v", i.Issuer, kid, err)
+		}
+	}
+	i.refetchMu.Unlock()
+
+	if key, ok := i.cachedKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key found for key ID: %s", kid)
+}
+
+func (i *Issuer) cachedKey(kid string) (crypto.PublicKey, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if kid == "" && len(i.keys) == 1 {
+		for _, key := range i.keys {
+			return key, true
+		}
+	}
+	key, ok := i.keys[kid]
+	return key, ok
+}
+
+// ValidateToken verifies tokenString with the keys of the issuer named by its
+// iss claim and checks that it carries one of the issuer's audiences.
+func ValidateToken(tokenString string) (*jwt.Token, error) {
+	unverified, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+	iss, err := unverified.Claims.GetIssuer()
+	if err != nil || iss == "" {
+		return nil, errors.New("token has no issuer")
+	}
+	issuer, err := getIssuer(iss)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(supportedAlgorithms), jwt.WithIssuer(iss), jwt.WithExpirationRequired())
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return issuer.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	audiences, err := token.Claims.GetAudience()
+	if err != nil {
+		return nil, err
+	}
+	for _, aud := range audiences {
+		for _, allowed := range issuer.Audiences {
+			if aud == allowed {
+				return token, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid audience: expected one of %s but got %v", strings.Join(issuer.Audiences, ", "), audiences)
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes the RSA and P-256 EC signing keys of a JWK set. Keys of
+// other types are skipped.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch jwk.Kty {
+		case "RSA":
+			key, err = rsaPublicKey(jwk)
+		case "EC":
+			key, err = ecPublicKey(jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %v", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func rsaPublicKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	modulus, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	exponent, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(exponent) >= 4 {
+		return nil, errors.New("exponent too large")
+	}
+	var e int
+	for _, b := range exponent {
+		e = e<<8 + int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: e}, nil
+}
+
+func ecPublicKey(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	if jwk.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("point is not on the curve")
+	}
+	return key, nil
+}
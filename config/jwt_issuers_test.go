@@ -0,0 +1,383 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testAudience = "plans-api"
+
+func rsaJWK(kid string, key *rsa.PublicKey) jsonWebKey {
+	return jsonWebKey{
+		Kid: kid,
+		Kty: "RSA",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) jsonWebKey {
+	return jsonWebKey{
+		Kid: kid,
+		Kty: "EC",
+		Use: "sig",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func jwksJSON(t *testing.T, keys ...jsonWebKey) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatalf("failed to marshal JWKS: %v", err)
+	}
+	return data
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offCurve := ecJWK("ec", &ecKey.PublicKey)
+	offCurve.Y = offCurve.X
+	p384 := ecJWK("ec", &ecKey.PublicKey)
+	p384.Crv = "P-384"
+	encryption := rsaJWK("enc", &rsaKey.PublicKey)
+	encryption.Use = "enc"
+	badModulus := rsaJWK("rsa", &rsaKey.PublicKey)
+	badModulus.N = "not base64!"
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantKeys map[string]crypto.PublicKey
+		wantErr  bool
+	}{
+		{
+			name:     "RSA key",
+			data:     jwksJSON(t, rsaJWK("rsa", &rsaKey.PublicKey)),
+			wantKeys: map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey},
+		},
+		{
+			name:     "EC key",
+			data:     jwksJSON(t, ecJWK("ec", &ecKey.PublicKey)),
+			wantKeys: map[string]crypto.PublicKey{"ec": &ecKey.PublicKey},
+		},
+		{
+			name:     "skips encryption and unknown key types",
+			data:     jwksJSON(t, encryption, jsonWebKey{Kid: "oct", Kty: "oct"}, rsaJWK("rsa", &rsaKey.PublicKey)),
+			wantKeys: map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey},
+		},
+		{name: "unsupported curve", data: jwksJSON(t, p384), wantErr: true},
+		{name: "point off the curve", data: jwksJSON(t, offCurve), wantErr: true},
+		{name: "malformed modulus", data: jwksJSON(t, badModulus), wantErr: true},
+		{name: "not JSON", data: []byte("<html>"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseJWKS(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got keys %v, want an error", keys)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(keys) != len(tt.wantKeys) {
+				t.Fatalf("got %d keys, want %d", len(keys), len(tt.wantKeys))
+			}
+			for kid, want := range tt.wantKeys {
+				got, ok := keys[kid]
+				if !ok {
+					t.Fatalf("key %s missing", kid)
+				}
+				if !want.(interface{ Equal(crypto.PublicKey) bool }).Equal(got) {
+					t.Errorf("key %s decoded to %v", kid, got)
+				}
+			}
+		})
+	}
+}
+
+// testIssuer serves an OIDC discovery document and a JWK set whose keys can
+// be swapped, and counts the key set fetches.
+type testIssuer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []byte
+	fetches int
+}
+
+func newTestIssuer(t *testing.T, keys []byte) *testIssuer {
+	t.Helper()
+	issuer := &testIssuer{keys: keys}
+	issuer.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":   issuer.URL,
+				"jwks_uri": issuer.URL + "/jwks",
+			})
+		case "/jwks":
+			issuer.mu.Lock()
+			issuer.fetches++
+			keys := issuer.keys
+			issuer.mu.Unlock()
+			w.Write(keys)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (i *testIssuer) setKeys(keys []byte) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keys = keys
+}
+
+func (i *testIssuer) fetchCount() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.fetches
+}
+
+// trustIssuers replaces the trusted issuers for the duration of the test.
+func trustIssuers(t *testing.T, configs ...IssuerConfig) {
+	t.Helper()
+	loaded := make(map[string]*Issuer, len(configs))
+	for _, cfg := range configs {
+		issuer := &Issuer{IssuerConfig: cfg}
+		if err := issuer.Refresh(); err != nil {
+			t.Fatalf("failed to load keys of %s: %v", cfg.Issuer, err)
+		}
+		loaded[cfg.Issuer] = issuer
+	}
+
+	issuersMu.Lock()
+	previous := issuers
+	issuers = loaded
+	issuersMu.Unlock()
+	t.Cleanup(func() {
+		issuersMu.Lock()
+		issuers = previous
+		issuersMu.Unlock()
+	})
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func TestValidateToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestIssuer(t, jwksJSON(t, rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ecKey.PublicKey)))
+	// The first issuer finds its keys through OIDC discovery
+	trustIssuers(t,
+		IssuerConfig{Issuer: server.URL, Audiences: []string{testAudience}},
+		IssuerConfig{Issuer: "https://other.example.com", JWKSURL: server.URL + "/jwks", Audiences: []string{"other-api"}},
+	)
+
+	claims := func(iss string, aud interface{}, exp time.Duration) jwt.MapClaims {
+		return jwt.MapClaims{"iss": iss, "aud": aud, "sub": "user", "exp": time.Now().Add(exp).Unix()}
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{
+			name:  "RS256",
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(server.URL, testAudience, time.Hour)),
+		},
+		{
+			name:  "ES256",
+			token: signToken(t, jwt.SigningMethodES256, "ec", ecKey, claims(server.URL, testAudience, time.Hour)),
+		},
+		{
+			name:  "one of several audiences",
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(server.URL, []string{"x", testAudience}, time.Hour)),
+		},
+		{
+			name:  "issuer with a configured JWKS URL",
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims("https://other.example.com", "other-api", time.Hour)),
+		},
+		{
+			name:    "audience of another issuer",
+			token:   signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(server.URL, "other-api", time.Hour)),
+			wantErr: "invalid audience",
+		},
+		{
+			name:    "untrusted issuer",
+			token:   signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims("https://evil.example.com", testAudience, time.Hour)),
+			wantErr: "untrusted issuer",
+		},
+		{
+			name:    "no issuer",
+			token:   signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"aud": testAudience, "exp": time.Now().Add(time.Hour).Unix()}),
+			wantErr: "no issuer",
+		},
+		{
+			name:    "expired",
+			token:   signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(server.URL, testAudience, -time.Hour)),
+			wantErr: "expired",
+		},
+		{
+			name:    "no expiry",
+			token:   signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"iss": server.URL, "aud": testAudience}),
+			wantErr: "exp claim is required",
+		},
+		{
+			name:    "signed by another key",
+			token:   signToken(t, jwt.SigningMethodRS256, "rsa", otherKey, claims(server.URL, testAudience, time.Hour)),
+			wantErr: "verification error",
+		},
+		{
+			name:    "key of the wrong type",
+			token:   signToken(t, jwt.SigningMethodRS256, "ec", rsaKey, claims(server.URL, testAudience, time.Hour)),
+			wantErr: "key is of invalid type",
+		},
+		{
+			name:    "symmetric algorithm",
+			token:   signToken(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), claims(server.URL, testAudience, time.Hour)),
+			wantErr: "signing method HS256 is invalid",
+		},
+		{
+			name:    "no kid with several keys",
+			token:   signToken(t, jwt.SigningMethodRS256, "", rsaKey, claims(server.URL, testAudience, time.Hour)),
+			wantErr: "no key found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateToken(tt.token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		want         time.Duration
+	}{
+		{"", defaultJWKSMaxAge},
+		{"no-cache", defaultJWKSMaxAge},
+		{"max-age=3600", time.Hour},
+		{"public, max-age=19800, must-revalidate", 19800 * time.Second},
+		{"Public, Max-Age=600", 10 * time.Minute},
+		{"max-age=5", minJWKSMaxAge},
+		{"max-age=0", minJWKSMaxAge},
+		{"max-age=604800", maxJWKSMaxAge},
+		{"max-age=soon", defaultJWKSMaxAge},
+	}
+
+	for _, tt := range tests {
+		if got := cacheMaxAge(tt.cacheControl); got != tt.want {
+			t.Errorf("cacheMaxAge(%q) = %s, want %s", tt.cacheControl, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownKidRefetch(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestIssuer(t, jwksJSON(t, rsaJWK("old", &oldKey.PublicKey)))
+	trustIssuers(t, IssuerConfig{Issuer: server.URL, JWKSURL: server.URL + "/jwks", Audiences: []string{testAudience}})
+	claims := jwt.MapClaims{"iss": server.URL, "aud": testAudience, "exp": time.Now().Add(time.Hour).Unix()}
+
+	// The issuer rotates to a new key after the key set was cached
+	server.setKeys(jwksJSON(t, rsaJWK("old", &oldKey.PublicKey), rsaJWK("new", &newKey.PublicKey)))
+
+	tests := []struct {
+		name        string
+		kid         string
+		key         *rsa.PrivateKey
+		wantErr     bool
+		wantFetches int
+	}{
+		{name: "cached kid", kid: "old", key: oldKey, wantFetches: 1},
+		{name: "rotated kid refetches", kid: "new", key: newKey, wantFetches: 2},
+		{name: "unknown kid within the interval", kid: "made-up", key: newKey, wantErr: true, wantFetches: 2},
+		{name: "known kid after refetch", kid: "new", key: newKey, wantFetches: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateToken(signToken(t, jwt.SigningMethodRS256, tt.kid, tt.key, claims))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if fetches := server.fetchCount(); fetches != tt.wantFetches {
+				t.Errorf("key set fetched %d times, want %d", fetches, tt.wantFetches)
+			}
+		})
+	}
+}
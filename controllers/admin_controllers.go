@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"csye7255-project-one/services"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	rebuildMu       sync.Mutex
+	rebuildRunning  bool
+	rebuildProgress *services.RebuildProgress
+	rebuildError    string
+)
+
+// StartRebuild handles POST /v1/admin/rebuild. It starts rebuilding the index
+// from the record store in the background; ?resume=true continues from the
+// last checkpoint and ?workers= bounds concurrency.
+func StartRebuild(c *gin.Context) {
+	workers, ok := intParam(c, "workers")
+	if !ok {
+		return
+	}
+	opts := services.RebuildOptions{
+		Workers:  workers,
+		Resume:   c.Query("resume") == "true",
+		Progress: setRebuildProgress,
+	}
+
+	rebuildMu.Lock()
+	if rebuildRunning {
+		rebuildMu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "A rebuild is already running"})
+		return
+	}
+	rebuildRunning = true
+	rebuildProgress = nil
+	rebuildError = ""
+	rebuildMu.Unlock()
+
+	go func() {
+		progress, err := services.Rebuild(context.Background(), recordStore, index, opts)
+		rebuildMu.Lock()
+		defer rebuildMu.Unlock()
+		rebuildRunning = false
+		rebuildProgress = progress
+		if err != nil {
+			log.Printf("Rebuild of %s failed: %v", index, err)
+			rebuildError = err.Error()
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Rebuild started"})
+}
+
+// GetRebuildStatus handles GET /v1/admin/rebuild.
+func GetRebuildStatus(c *gin.Context) {
+	rebuildMu.Lock()
+	defer rebuildMu.Unlock()
+
+	status := gin.H{"running": rebuildRunning, "progress": rebuildProgress}
+	if rebuildError != "" {
+		status["error"] = rebuildError
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func setRebuildProgress(progress services.RebuildProgress) {
+	progress.Failed = append([]string{}, progress.Failed...)
+
+	rebuildMu.Lock()
+	defer rebuildMu.Unlock()
+	rebuildProgress = &progress
+}
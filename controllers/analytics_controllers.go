@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"csye7255-project-one/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The analytics endpoints accept the same filters as query parameters:
+// _org, planType, from and to (inclusive MM-DD-YYYY bounds on creationDate)
+// and size, the number of groups to return.
+
+// GetDeductibleAnalytics handles GET /v1/analytics/deductibles.
+func GetDeductibleAnalytics(c *gin.Context) {
+	filter, ok := analyticsFilter(c)
+	if !ok {
+		return
+	}
+	report, err := services.AverageDeductibles(services.SearchIndex(index, filter.Orgs), filter)
+	respondWithReport(c, report, err)
+}
+
+// GetCopayAnalytics handles GET /v1/analytics/copays; interval sets the width
+// of the copay histogram buckets.
+func GetCopayAnalytics(c *gin.Context) {
+	filter, ok := analyticsFilter(c)
+	if !ok {
+		return
+	}
+	interval, ok := intParam(c, "interval")
+	if !ok {
+		return
+	}
+	report, err := services.CopayDistribution(services.SearchIndex(index, filter.Orgs), filter, interval)
+	respondWithReport(c, report, err)
+}
+
+// GetPlanCountAnalytics handles GET /v1/analytics/plan-counts; interval is
+// one of day, week, month (default), quarter or year.
+func GetPlanCountAnalytics(c *gin.Context) {
+	filter, ok := analyticsFilter(c)
+	if !ok {
+		return
+	}
+	report, err := services.PlanCounts(services.SearchIndex(index, filter.Orgs), filter, c.Query("interval"))
+	respondWithReport(c, report, err)
+}
+
+func analyticsFilter(c *gin.Context) (services.AnalyticsFilter, bool) {
+	size, ok := intParam(c, "size")
+	if !ok {
+		return services.AnalyticsFilter{}, false
+	}
+	return services.AnalyticsFilter{
+		Org:         c.Query("_org"),
+		PlanType:    c.Query("planType"),
+		CreatedFrom: c.Query("from"),
+		CreatedTo:   c.Query("to"),
+		Size:        size,
+		Orgs:        callerOrgs(c),
+	}, true
+}
+
+func intParam(c *gin.Context, name string) (int, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an integer"})
+		return 0, false
+	}
+	return n, true
+}
+
+func respondWithReport(c *gin.Context, report interface{}, err error) {
+	if errors.Is(err, services.ErrInvalidAnalyticsQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run aggregation in Elasticsearch"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"csye7255-project-one/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var apiKeyStore services.APIKeyStore
+
+// SetAPIKeyStore sets the store used by the API key handlers.
+func SetAPIKeyStore(store services.APIKeyStore) {
+	apiKeyStore = store
+}
+
+type apiKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Orgs      []string   `json:"orgs"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreateAPIKey handles POST /v1/admin/api-keys. The plaintext key is only
+// returned in this response.
+func CreateAPIKey(c *gin.Context) {
+	var request apiKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON data"})
+		return
+	}
+
+	// Admins can only hand out access to orgs they have themselves
+	for _, org := range request.Orgs {
+		if !services.OrgAllowed(callerOrgs(c), org) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Caller may not bind keys to _org " + org})
+			return
+		}
+	}
+
+	key, plaintext, err := services.CreateAPIKey(apiKeyStore, request.Name, request.Scopes, request.Orgs, request.ExpiresAt)
+	if errors.Is(err, services.ErrInvalidAPIKeyRequest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"apiKey": key.Public(), "key": plaintext})
+}
+
+// canManageAPIKey reports whether the caller is bound to every org of key, the
+// same rule CreateAPIKey applies. Other keys are hidden as not found.
+func canManageAPIKey(c *gin.Context, key services.APIKey) bool {
+	for _, org := range key.Orgs {
+		if !services.OrgAllowed(callerOrgs(c), org) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadManagedAPIKey fetches the key a request refers to, writing the error
+// response itself when it returns false.
+func loadManagedAPIKey(c *gin.Context) bool {
+	key, err := apiKeyStore.GetAPIKey(c.Param("keyId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API key"})
+		return false
+	} else if key == nil || !canManageAPIKey(c, *key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return false
+	}
+	return true
+}
+
+// ListAPIKeys handles GET /v1/admin/api-keys, listing the keys the caller may
+// manage.
+func ListAPIKeys(c *gin.Context) {
+	keys, err := apiKeyStore.ListAPIKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	items := make([]services.APIKey, 0, len(keys))
+	for _, key := range keys {
+		if canManageAPIKey(c, key) {
+			items = append(items, key.Public())
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// RotateAPIKey handles POST /v1/admin/api-keys/:keyId/rotate.
+func RotateAPIKey(c *gin.Context) {
+	if !loadManagedAPIKey(c) {
+		return
+	}
+
+	key, plaintext, err := services.RotateAPIKey(apiKeyStore, c.Param("keyId"))
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKey": key.Public(), "key": plaintext})
+}
+
+// RevokeAPIKey handles DELETE /v1/admin/api-keys/:keyId.
+func RevokeAPIKey(c *gin.Context) {
+	if !loadManagedAPIKey(c) {
+		return
+	}
+
+	err := services.RevokeAPIKey(apiKeyStore, c.Param("keyId"))
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"csye7255-project-one/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newAuditInfo describes the caller and request behind a write for the
+// record's audit log.
+func newAuditInfo(c *gin.Context, operation, resource string) *services.AuditInfo {
+	return &services.AuditInfo{
+		Operation: operation,
+		Resource:  resource,
+		Actor:     auditActor(c),
+		RequestID: c.GetString("requestId"),
+	}
+}
+
+func auditActor(c *gin.Context) services.Actor {
+	if value, ok := c.Get("apiKey"); ok {
+		key, _ := value.(services.APIKey)
+		return services.Actor{APIKeyID: key.ID, APIKeyName: key.Name}
+	}
+
+	value, _ := c.Get("user")
+	claims, _ := value.(jwt.MapClaims)
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	return services.Actor{Subject: subject, Email: email}
+}
+
+// GetAuditLog handles GET /v1/plans/:id/audit, the changes of a plan oldest
+// first, limited to the entries of the caller's orgs. The log stays readable
+// after the plan is deleted.
+func GetAuditLog(c *gin.Context) {
+	limit := defaultPageSize
+	if rawLimit := c.Query("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+			return
+		}
+		limit = parsed
+	}
+
+	page, err := recordStore.AuditLog(c.Param("id"), c.Query("cursor"), limit)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read the audit log from Redis"})
+		return
+	}
+
+	// The objectId may have been deleted and created again by another org,
+	// so every entry is checked on its own
+	entries := []services.AuditEntry{}
+	for _, entry := range page.Entries {
+		if services.OrgAllowed(callerOrgs(c), entry.Org) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 && page.NextCursor == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Audit log not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate")
+	c.JSON(http.StatusOK, gin.H{
+		"items":      entries,
+		"nextCursor": page.NextCursor,
+	})
+}
@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"csye7255-project-one/config"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Readiness handles GET /readyz. The service is not ready to authenticate
+// requests until the signing keys of at least one issuer are loaded.
+func Readiness(c *gin.Context) {
+	if !config.IssuersReady() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"ready": false, "error": "No JWT signing keys loaded"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ready": true})
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"csye7255-project-one/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// canAccessVersion reports whether the caller may see a version. Each version
+// is checked on its own since the objectId may have been deleted and created
+// again by another org.
+func canAccessVersion(c *gin.Context, version services.PlanVersion) bool {
+	return services.OrgAllowed(callerOrgs(c), version.Org)
+}
+
+// ListVersions handles GET /v1/plans/:id/versions, the committed versions of
+// a plan oldest first, without their records, limited to the caller's orgs.
+func ListVersions(c *gin.Context) {
+	limit := defaultPageSize
+	if rawLimit := c.Query("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+			return
+		}
+		limit = parsed
+	}
+
+	page, err := recordStore.Versions(c.Param("id"), c.Query("cursor"), limit)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read versions from Redis"})
+		return
+	}
+
+	versions := []services.PlanVersion{}
+	for _, version := range page.Versions {
+		if canAccessVersion(c, version) {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 && page.NextCursor == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate")
+	c.JSON(http.StatusOK, gin.H{
+		"items":      versions,
+		"nextCursor": page.NextCursor,
+	})
+}
+
+// GetVersion handles GET /v1/plans/:id/versions/:n.
+func GetVersion(c *gin.Context) {
+	n, err := strconv.ParseInt(c.Param("n"), 10, 64)
+	if err != nil || n < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Version must be a positive integer"})
+		return
+	}
+
+	version, err := recordStore.GetVersion(c.Param("id"), n)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read version from Redis"})
+		return
+	} else if version == nil || !canAccessVersion(c, *version) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+
+	// Versions never change once committed
+	c.Header("Cache-Control", "private, max-age=86400, immutable")
+	c.JSON(http.StatusOK, version)
+}
+
+// getRecordAsOf serves GET /v1/plans/:id?asOf=, the plan as it was at a point
+// in time. Content-Location names the version that was current.
+func getRecordAsOf(c *gin.Context, id, rawAsOf string) {
+	asOf, err := parseAsOf(rawAsOf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	version, err := recordStore.GetVersionAsOf(id, asOf)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read version from Redis"})
+		return
+	} else if version == nil || version.Deleted || !canAccessVersion(c, *version) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate")
+	c.Header("Content-Location", fmt.Sprintf("/v1/plans/%s/versions/%d", id, version.Version))
+	c.Header("ETag", version.ETag)
+	c.JSON(http.StatusOK, version.Record)
+}
+
+// parseAsOf accepts an RFC 3339 timestamp or a date, which stands for the end
+// of that day in UTC.
+func parseAsOf(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if day, err := time.Parse("2006-01-02", raw); err == nil {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return time.Time{}, errors.New("asOf must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"csye7255-project-one/models"
+	"csye7255-project-one/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// callerOrgs returns the orgs RequirePermission bound the caller to.
+func callerOrgs(c *gin.Context) []string {
+	return c.GetStringSlice("orgs")
+}
+
+// canAccessRecord reports whether the caller may see a stored plan. Plans of
+// other orgs are reported as not found rather than forbidden, so their
+// existence is not revealed.
+func canAccessRecord(c *gin.Context, record map[string]interface{}) bool {
+	org, _ := record["_org"].(string)
+	return services.OrgAllowed(callerOrgs(c), org)
+}
+
+// checkPlanOrgs rejects a plan payload whose children belong to another _org
+// than the plan, or whose _org the caller is not bound to.
+func checkPlanOrgs(c *gin.Context, plan models.Plan) bool {
+	if path := services.PlanOrgMismatch(plan); path != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": path + " _org must match the plan's _org"})
+		return false
+	}
+	if !services.OrgAllowed(callerOrgs(c), plan.Org) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Caller may not write plans of _org " + plan.Org})
+		return false
+	}
+	return true
+}
+
+// checkOrgUnchanged rejects moving a stored plan to another _org.
+func checkOrgUnchanged(c *gin.Context, existing map[string]interface{}, plan models.Plan) bool {
+	if org, _ := existing["_org"].(string); org != plan.Org {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "_org cannot be changed"})
+		return false
+	}
+	return true
+}
@@ -5,18 +5,32 @@ import (
 	"csye7255-project-one/services"
 	"csye7255-project-one/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 )
 
 var (
 	index     = "plans"
 	queueName = "plan_requests"
+
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	recordStore services.RecordStore
 )
 
+// SetRecordStore sets the store used by the record handlers.
+func SetRecordStore(store services.RecordStore) {
+	recordStore = store
+}
+
 func CreateRecord(c *gin.Context) {
 	var plan models.Plan
 
@@ -29,34 +43,45 @@ func CreateRecord(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !checkPlanOrgs(c, plan) {
+		return
+	}
 
-	exists, err := services.CheckIfRecordExists(plan.ObjectId)
+	existing, version, err := recordStore.GetWithVersion(plan.ObjectId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existence of the record"})
 		return
 	}
-	if exists {
+	if existing != nil {
 		c.Status(http.StatusConflict)
 		return
 	}
 
-	err = services.SaveRecord(plan.ObjectId, plan)
-	if err != nil {
+	event := newOperationEvent("POST", services.IndexForOrg(index, plan.Org), plan.ObjectId, plan)
+
+	// A concurrent create of the same objectId bumps the version
+	err = recordStore.Save(plan.ObjectId, plan, services.WriteOptions{
+		Events:    []services.ChangeEvent{event},
+		Audit:     newAuditInfo(c, "POST", ""),
+		IfVersion: &version,
+	})
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.Status(http.StatusConflict)
+		return
+	} else if errors.Is(err, services.ErrRecordDeleted) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A deleted record with this objectId can still be restored"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save data to Redis"})
 		return
 	}
 
-	savedRecord, err := services.GetRecord(plan.ObjectId)
+	savedRecord, err := recordStore.Get(plan.ObjectId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch saved data from Redis"})
 		return
 	}
 
-	if err := PublishOperationToQueue("POST", index, plan.ObjectId, plan); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish message to RabbitMQ"})
-		return
-	}
-
 	savedRecordJSON, err := json.Marshal(savedRecord)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert record to JSON"})
@@ -70,13 +95,18 @@ func CreateRecord(c *gin.Context) {
 
 func GetRecord(c *gin.Context) {
 	id := c.Param("id")
-	record, err := services.GetRecord(id)
-	if err == redis.Nil || record == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+	if asOf := c.Query("asOf"); asOf != "" {
+		getRecordAsOf(c, id, asOf)
 		return
-	} else if err != nil {
+	}
+
+	record, err := recordStore.Get(id)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data from Redis"})
 		return
+	} else if record == nil || !canAccessRecord(c, record) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
 	}
 	recordJSON, err := json.Marshal(record)
 	if err != nil {
@@ -101,15 +131,43 @@ func GetRecord(c *gin.Context) {
 	c.Data(http.StatusOK, "application/json", recordJSON)
 }
 
-func PatchRecord(c *gin.Context) {
-	id := c.Param("id")
-	existingRecord, err := services.GetRecord(id)
-	if err == redis.Nil || existingRecord == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+func ListRecords(c *gin.Context) {
+	limit := defaultPageSize
+	if rawLimit := c.Query("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+			return
+		}
+		limit = parsed
+	}
+
+	page, err := recordStore.List(c.Query("cursor"), limit, callerOrgs(c)...)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
 		return
 	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list data from Redis"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate")
+	c.JSON(http.StatusOK, gin.H{
+		"items":      page.Records,
+		"total":      page.Total,
+		"nextCursor": page.NextCursor,
+	})
+}
+
+func PatchRecord(c *gin.Context) {
+	id := c.Param("id")
+	existingRecord, version, err := recordStore.GetWithVersion(id)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data from Redis"})
 		return
+	} else if existingRecord == nil || !canAccessRecord(c, existingRecord) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
 	}
 
 	existingRecordJSON, err := json.Marshal(existingRecord)
@@ -143,12 +201,111 @@ func PatchRecord(c *gin.Context) {
 		return
 	}
 
-	var updates map[string]interface{}
-	if err := c.ShouldBindJSON(&updates); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON data"})
+	switch c.ContentType() {
+	case mergePatchContentType, jsonPatchContentType:
+		var patched models.Plan
+		if status, err := applyStandardPatch(c, existingRecord, &patched); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		if patched.ObjectId != id {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "objectId cannot be changed"})
+			return
+		}
+		plan = patched
+	default:
+		var updates map[string]interface{}
+		if err := c.ShouldBindJSON(&updates); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON data"})
+			return
+		}
+		if err := applyLegacyPatch(&plan, updates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply updates"})
+			return
+		}
+	}
+
+	if err := utils.ValidateStruct(plan); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkOrgUnchanged(c, existingRecord, plan) || !checkPlanOrgs(c, plan) {
+		return
+	}
+
+	event := newOperationEvent("PATCH", services.IndexForOrg(index, plan.Org), id, plan)
+
+	err = recordStore.Save(id, plan, services.WriteOptions{
+		Events:    []services.ChangeEvent{event},
+		Audit:     newAuditInfo(c, "PATCH", ""),
+		IfVersion: &version,
+	})
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "ETag mismatch. The resource has been modified by another process."})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update data"})
 		return
 	}
 
+	savedRecord, err := recordStore.Get(plan.ObjectId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch saved data from Redis"})
+		return
+	}
+	savedRecordJSON, err := json.Marshal(savedRecord)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert updated record to JSON"})
+		return
+	}
+	etag := utils.GenerateETag(savedRecordJSON)
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, plan)
+}
+
+// applyStandardPatch applies an RFC 7396 merge patch or RFC 6902 JSON patch
+// body to doc and decodes the result into target. The returned status is the
+// response code to use when err is non-nil.
+func applyStandardPatch(c *gin.Context, doc interface{}, target interface{}) (int, error) {
+	body, err := c.GetRawData()
+	if err != nil {
+		return http.StatusBadRequest, errors.New("Failed to read request body")
+	}
+
+	var patched interface{}
+	if c.ContentType() == mergePatchContentType {
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return http.StatusBadRequest, errors.New("Invalid JSON data")
+		}
+		patched = utils.ApplyMergePatch(doc, patch)
+	} else {
+		var ops []utils.PatchOperation
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return http.StatusBadRequest, errors.New("Invalid JSON Patch document")
+		}
+		patched, err = utils.ApplyJSONPatch(doc, ops)
+		if errors.Is(err, utils.ErrPatchTestFailed) {
+			return http.StatusConflict, err
+		} else if err != nil {
+			return http.StatusUnprocessableEntity, err
+		}
+	}
+
+	patchedJSON, err := json.Marshal(patched)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("Failed to encode patched record")
+	}
+	if err := json.Unmarshal(patchedJSON, target); err != nil {
+		return http.StatusUnprocessableEntity, errors.New("Patched document does not match the resource schema")
+	}
+	return http.StatusOK, nil
+}
+
+// applyLegacyPatch is the plain application/json PATCH behaviour:
+// linkedPlanServices are upserted by objectId and every other member is
+// overlaid onto plan.
+func applyLegacyPatch(plan *models.Plan, updates map[string]interface{}) error {
 	if newLinkedServices, ok := updates["linkedPlanServices"].([]interface{}); ok {
 		for _, ls := range newLinkedServices {
 			var newService models.LinkedPlanService
@@ -173,42 +330,9 @@ func PatchRecord(c *gin.Context) {
 
 	updatesJSON, err := json.Marshal(updates)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode updates"})
-		return
-	}
-	if err := json.Unmarshal(updatesJSON, &plan); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply updates"})
-		return
-	}
-
-	if err := utils.ValidateStruct(plan); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	if err := services.SaveRecord(id, plan); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update data"})
-		return
-	}
-
-	if err := PublishOperationToQueue("PATCH", index, id, plan); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish operation to RabbitMQ"})
-		return
+		return err
 	}
-
-	savedRecord, err := services.GetRecord(plan.ObjectId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch saved data from Redis"})
-		return
-	}
-	savedRecordJSON, err := json.Marshal(savedRecord)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert updated record to JSON"})
-		return
-	}
-	etag := utils.GenerateETag(savedRecordJSON)
-	c.Header("ETag", etag)
-	c.JSON(http.StatusOK, plan)
+	return json.Unmarshal(updatesJSON, plan)
 }
 
 func PutRecord(c *gin.Context) {
@@ -225,13 +349,13 @@ func PutRecord(c *gin.Context) {
 		return
 	}
 
-	existingRecord, err := services.GetRecord(id)
-	if err == redis.Nil || existingRecord == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
-		return
-	} else if err != nil {
+	existingRecord, version, err := recordStore.GetWithVersion(id)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data from Redis"})
 		return
+	} else if existingRecord == nil || !canAccessRecord(c, existingRecord) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
 	}
 
 	var existingRecordJSON []byte
@@ -262,17 +386,26 @@ func PutRecord(c *gin.Context) {
 		return
 	}
 
-	if err := services.SaveRecord(id, newRecord); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save data to Redis"})
+	if !checkOrgUnchanged(c, existingRecord, newRecord) || !checkPlanOrgs(c, newRecord) {
 		return
 	}
 
-	if err := PublishOperationToQueue("PUT", index, id, newRecord); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish operation to RabbitMQ"})
+	event := newOperationEvent("PUT", services.IndexForOrg(index, newRecord.Org), id, newRecord)
+
+	err = recordStore.Save(id, newRecord, services.WriteOptions{
+		Events:    []services.ChangeEvent{event},
+		Audit:     newAuditInfo(c, "PUT", ""),
+		IfVersion: &version,
+	})
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "ETag mismatch. The resource has been modified by another process."})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save data to Redis"})
 		return
 	}
 
-	savedRecord, err := services.GetRecord(id)
+	savedRecord, err := recordStore.Get(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch saved data from Redis"})
 		return
@@ -290,13 +423,13 @@ func PutRecord(c *gin.Context) {
 func DeleteRecord(c *gin.Context) {
 	id := c.Param("id")
 
-	existingRecord, err := services.GetRecord(id)
-	if err == redis.Nil || existingRecord == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
-		return
-	} else if err != nil {
+	existingRecord, version, err := recordStore.GetWithVersion(id)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data from Redis"})
 		return
+	} else if existingRecord == nil || !canAccessRecord(c, existingRecord) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return
 	}
 
 	existingRecordJSON, err := json.Marshal(existingRecord)
@@ -324,31 +457,106 @@ func DeleteRecord(c *gin.Context) {
 		return
 	}
 
-	err = services.DeleteRecord(id)
-	if err != nil {
+	event := newOperationEvent("DELETE", services.IndexForOrg(index, plan.Org), id, nil)
+
+	err = recordStore.Delete(id, services.WriteOptions{
+		Events:    []services.ChangeEvent{event},
+		Audit:     newAuditInfo(c, "DELETE", ""),
+		IfVersion: &version,
+	})
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "ETag mismatch. The resource has been modified by another process."})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete data from Redis"})
 		return
 	}
 
-	if err := PublishOperationToQueue("DELETE", index, id, nil); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish delete operation to RabbitMQ"})
+	c.Status(http.StatusNoContent)
+}
+
+// RestoreRecord handles POST /v1/plans/:id/restore, bringing back a deleted
+// plan within the retention window and indexing it again.
+func RestoreRecord(c *gin.Context) {
+	id := c.Param("id")
+
+	tombstone, err := recordStore.GetDeleted(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data from Redis"})
+		return
+	} else if tombstone == nil || !services.OrgAllowed(callerOrgs(c), tombstone.Org) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted record not found"})
+		return
+	}
+	if tombstone.Expired() {
+		c.JSON(http.StatusGone, gin.H{"error": "Deleted record is past its retention window"})
 		return
 	}
 
-	c.Status(http.StatusNoContent)
-}
+	var plan models.Plan
+	if err := json.Unmarshal(tombstone.Record, &plan); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse deleted record"})
+		return
+	}
+
+	event := newOperationEvent("POST", services.IndexForOrg(index, plan.Org), id, plan)
 
-func PublishOperationToQueue(operation, index, docID string, payload interface{}) error {
-	message := map[string]interface{}{
-		"operation": operation,
-		"index":     index,
-		"doc_id":    docID,
-		"payload":   payload,
+	err = recordStore.Restore(id, tombstone.Version, services.WriteOptions{
+		Events: []services.ChangeEvent{event},
+		Audit:  newAuditInfo(c, "RESTORE", ""),
+	})
+	if errors.Is(err, services.ErrRecordExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A record with this objectId exists"})
+		return
+	} else if errors.Is(err, services.ErrNotDeleted) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The record was changed by another process"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore data in Redis"})
+		return
 	}
-	messageJSON, err := json.Marshal(message)
+
+	savedRecord, err := recordStore.Get(id)
 	if err != nil {
-		return fmt.Errorf("failed to serialize message: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch saved data from Redis"})
+		return
 	}
+	savedRecordJSON, err := json.Marshal(savedRecord)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert record to JSON"})
+		return
+	}
+	etag := utils.GenerateETag(savedRecordJSON)
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, savedRecord)
+}
 
-	return services.PublishMessage(queueName, messageJSON)
+// newOperationEvent builds the sync message for a whole-plan change. It is
+// written to the outbox together with the record and relayed to RabbitMQ.
+func newOperationEvent(operation, index, docID string, payload interface{}) services.ChangeEvent {
+	return services.ChangeEvent{
+		Queue: queueName,
+		Message: map[string]interface{}{
+			"operation": operation,
+			"index":     index,
+			"doc_id":    docID,
+			"payload":   payload,
+		},
+	}
+}
+
+// newChildOperationEvent builds the sync message for a change to one
+// sub-resource of a plan so the consumer only rewrites that part of the index.
+func newChildOperationEvent(operation, index, planID, resource, childID string, payload interface{}) services.ChangeEvent {
+	return services.ChangeEvent{
+		Queue: queueName,
+		Message: map[string]interface{}{
+			"operation": operation,
+			"index":     index,
+			"doc_id":    planID,
+			"resource":  resource,
+			"child_id":  childID,
+			"payload":   payload,
+		},
+	}
 }
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"csye7255-project-one/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SearchRecords handles GET and POST /v1/plans/_search. POST takes a
+// services.SearchQuery body; GET takes a single clause as query parameters,
+// e.g. ?type=planCostShares&deductible.gte=1000&_org=example.com or
+// ?creationDate.gte=01-01-2017.
+func SearchRecords(c *gin.Context) {
+	var query services.SearchQuery
+	if c.Request.Method == http.MethodPost {
+		if err := c.ShouldBindJSON(&query); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON data"})
+			return
+		}
+	} else {
+		parsed, err := searchQueryFromParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query = parsed
+	}
+
+	query.Orgs = callerOrgs(c)
+	result, err := services.SearchPlans(services.SearchIndex(index, query.Orgs), query)
+	if errors.Is(err, services.ErrInvalidSearchQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search Elasticsearch"})
+		return
+	}
+
+	items := []map[string]interface{}{}
+	for _, id := range result.PlanIDs {
+		record, err := recordStore.Get(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data from Redis"})
+			return
+		}
+		// The index may briefly lag behind deletes
+		if record != nil && canAccessRecord(c, record) {
+			items = append(items, record)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":   result.Total,
+		"planIds": result.PlanIDs,
+		"items":   items,
+	})
+}
+
+func searchQueryFromParams(c *gin.Context) (services.SearchQuery, error) {
+	query := services.SearchQuery{
+		Type:    c.Query("type"),
+		Filters: map[string]interface{}{},
+		Ranges:  map[string]services.RangeFilter{},
+	}
+
+	for key, values := range c.Request.URL.Query() {
+		value := values[0]
+		switch key {
+		case "type":
+			continue
+		case "from", "size":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return query, errors.New(key + " must be an integer")
+			}
+			if key == "from" {
+				query.From = n
+			} else {
+				query.Size = n
+			}
+			continue
+		}
+
+		field, op, isRange := strings.Cut(key, ".")
+		if !isRange {
+			query.Filters[key] = value
+			continue
+		}
+
+		// Numbers bound numeric fields, anything else is taken as a date
+		var bound interface{} = value
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			bound = number
+		}
+		r := query.Ranges[field]
+		switch op {
+		case "gt":
+			r.Gt = bound
+		case "gte":
+			r.Gte = bound
+		case "lt":
+			r.Lt = bound
+		case "lte":
+			r.Lte = bound
+		default:
+			return query, errors.New("unknown range operator: " + op)
+		}
+		query.Ranges[field] = r
+	}
+
+	return query, nil
+}
@@ -0,0 +1,333 @@
+package controllers
+
+import (
+	"csye7255-project-one/models"
+	"csye7255-project-one/services"
+	"csye7255-project-one/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadPlan fetches and decodes the plan a sub-resource request refers to along
+// with its version, writing the error response itself when it returns false.
+func loadPlan(c *gin.Context, id string) (models.Plan, int64, bool) {
+	var plan models.Plan
+
+	record, version, err := recordStore.GetWithVersion(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data from Redis"})
+		return plan, 0, false
+	} else if record == nil || !canAccessRecord(c, record) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+		return plan, 0, false
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize existing record"})
+		return plan, 0, false
+	}
+	if err := json.Unmarshal(recordJSON, &plan); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse existing record"})
+		return plan, 0, false
+	}
+	return plan, version, true
+}
+
+// savePlan validates and stores plan after a sub-resource change together
+// with the partial sync operation for the changed child. The write only
+// succeeds while the plan is still at the version loadPlan read, so the
+// If-Match checked against that read cannot be overtaken by another writer.
+func savePlan(c *gin.Context, plan models.Plan, version int64, operation, resource, childID string, payload interface{}) bool {
+	if err := utils.ValidateStruct(plan); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if !checkPlanOrgs(c, plan) {
+		return false
+	}
+
+	event := newChildOperationEvent(operation, services.IndexForOrg(index, plan.Org), plan.ObjectId, resource, childID, payload)
+	err := recordStore.Save(plan.ObjectId, plan, services.WriteOptions{
+		Events:    []services.ChangeEvent{event},
+		Audit:     newAuditInfo(c, operation, resource),
+		IfVersion: &version,
+	})
+	if errors.Is(err, services.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "ETag mismatch. The resource has been modified by another process."})
+		return false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save data to Redis"})
+		return false
+	}
+	return true
+}
+
+// checkIfMatch enforces a required If-Match header against the current ETag of
+// a sub-resource.
+func checkIfMatch(c *gin.Context, current interface{}) bool {
+	clientIfMatch := c.GetHeader("If-Match")
+	if clientIfMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header is required"})
+		return false
+	}
+	if clientIfMatch != utils.GenerateETag(current) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "ETag mismatch. The resource has been modified by another process."})
+		return false
+	}
+	return true
+}
+
+func respondWithETag(c *gin.Context, status int, resource interface{}) {
+	etag := utils.GenerateETag(resource)
+	c.Header("ETag", etag)
+
+	if c.Request.Method == http.MethodGet {
+		c.Header("Cache-Control", "no-store, no-cache, must-revalidate")
+		if match := c.GetHeader("If-None-Match"); match == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	c.JSON(status, resource)
+}
+
+// patchResource applies a PATCH body to current and decodes the result into
+// target. Plain application/json bodies are overlaid onto current.
+func patchResource(c *gin.Context, current interface{}, target interface{}) bool {
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize existing record"})
+		return false
+	}
+
+	switch c.ContentType() {
+	case mergePatchContentType, jsonPatchContentType:
+		var doc interface{}
+		if err := json.Unmarshal(currentJSON, &doc); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse existing record"})
+			return false
+		}
+		if status, err := applyStandardPatch(c, doc, target); err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return false
+		}
+	default:
+		if err := json.Unmarshal(currentJSON, target); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse existing record"})
+			return false
+		}
+		if err := c.ShouldBindJSON(target); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON data"})
+			return false
+		}
+	}
+	return true
+}
+
+func findLinkedPlanService(plan models.Plan, serviceID string) int {
+	for i, service := range plan.LinkedPlanServices {
+		if service.ObjectId == serviceID {
+			return i
+		}
+	}
+	return -1
+}
+
+func GetLinkedPlanServices(c *gin.Context) {
+	plan, _, ok := loadPlan(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	respondWithETag(c, http.StatusOK, plan.LinkedPlanServices)
+}
+
+func CreateLinkedPlanService(c *gin.Context) {
+	var service models.LinkedPlanService
+	if err := c.ShouldBindJSON(&service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := utils.ValidateStruct(service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, version, ok := loadPlan(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if findLinkedPlanService(plan, service.ObjectId) >= 0 {
+		c.Status(http.StatusConflict)
+		return
+	}
+
+	plan.LinkedPlanServices = append(plan.LinkedPlanServices, service)
+	if !savePlan(c, plan, version, "POST", "linkedPlanServices", service.ObjectId, service) {
+		return
+	}
+	respondWithETag(c, http.StatusCreated, service)
+}
+
+func GetLinkedPlanService(c *gin.Context) {
+	plan, _, ok := loadPlan(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	i := findLinkedPlanService(plan, c.Param("serviceId"))
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Linked plan service not found"})
+		return
+	}
+	respondWithETag(c, http.StatusOK, plan.LinkedPlanServices[i])
+}
+
+func PutLinkedPlanService(c *gin.Context) {
+	serviceID := c.Param("serviceId")
+
+	var service models.LinkedPlanService
+	if err := c.ShouldBindJSON(&service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON data"})
+		return
+	}
+	if err := utils.ValidateStruct(service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if service.ObjectId != serviceID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "objectId does not match the URL"})
+		return
+	}
+
+	plan, version, ok := loadPlan(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	i := findLinkedPlanService(plan, serviceID)
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Linked plan service not found"})
+		return
+	}
+	if !checkIfMatch(c, plan.LinkedPlanServices[i]) {
+		return
+	}
+
+	plan.LinkedPlanServices[i] = service
+	if !savePlan(c, plan, version, "PUT", "linkedPlanServices", serviceID, service) {
+		return
+	}
+	respondWithETag(c, http.StatusOK, service)
+}
+
+func PatchLinkedPlanService(c *gin.Context) {
+	serviceID := c.Param("serviceId")
+
+	plan, version, ok := loadPlan(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	i := findLinkedPlanService(plan, serviceID)
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Linked plan service not found"})
+		return
+	}
+	if !checkIfMatch(c, plan.LinkedPlanServices[i]) {
+		return
+	}
+
+	var service models.LinkedPlanService
+	if !patchResource(c, plan.LinkedPlanServices[i], &service) {
+		return
+	}
+	if service.ObjectId != serviceID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "objectId cannot be changed"})
+		return
+	}
+
+	plan.LinkedPlanServices[i] = service
+	if !savePlan(c, plan, version, "PATCH", "linkedPlanServices", serviceID, service) {
+		return
+	}
+	respondWithETag(c, http.StatusOK, service)
+}
+
+func DeleteLinkedPlanService(c *gin.Context) {
+	serviceID := c.Param("serviceId")
+
+	plan, version, ok := loadPlan(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	i := findLinkedPlanService(plan, serviceID)
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Linked plan service not found"})
+		return
+	}
+	if !checkIfMatch(c, plan.LinkedPlanServices[i]) {
+		return
+	}
+
+	plan.LinkedPlanServices = append(plan.LinkedPlanServices[:i], plan.LinkedPlanServices[i+1:]...)
+	if !savePlan(c, plan, version, "DELETE", "linkedPlanServices", serviceID, nil) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func GetPlanCostShares(c *gin.Context) {
+	plan, _, ok := loadPlan(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	respondWithETag(c, http.StatusOK, plan.PlanCostShares)
+}
+
+func PutPlanCostShares(c *gin.Context) {
+	var costShares models.PlanCostShares
+	if err := c.ShouldBindJSON(&costShares); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON data"})
+		return
+	}
+	if err := utils.ValidateStruct(costShares); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, version, ok := loadPlan(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if !checkIfMatch(c, plan.PlanCostShares) {
+		return
+	}
+
+	plan.PlanCostShares = costShares
+	if !savePlan(c, plan, version, "PUT", "planCostShares", costShares.ObjectId, costShares) {
+		return
+	}
+	respondWithETag(c, http.StatusOK, costShares)
+}
+
+func PatchPlanCostShares(c *gin.Context) {
+	plan, version, ok := loadPlan(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	if !checkIfMatch(c, plan.PlanCostShares) {
+		return
+	}
+
+	var costShares models.PlanCostShares
+	if !patchResource(c, plan.PlanCostShares, &costShares) {
+		return
+	}
+
+	plan.PlanCostShares = costShares
+	if !savePlan(c, plan, version, "PATCH", "planCostShares", costShares.ObjectId, costShares) {
+		return
+	}
+	respondWithETag(c, http.StatusOK, costShares)
+}
@@ -1,21 +1,27 @@
 package main
 
 import (
+	"context"
 	"csye7255-project-one/config"
+	"csye7255-project-one/controllers"
 	"csye7255-project-one/middleware"
 	"csye7255-project-one/routes"
 	"csye7255-project-one/services"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
 var (
-	indexName = "plans"
-	queueName = "plan_requests"
+	indexName      = "plans"
+	queueName      = "plan_requests"
+	recordsKey     = "plans"
+	apiKeysKey     = "apikeys"
+	idempotencyKey = "idempotency"
 )
 
 func main() {
@@ -24,31 +30,76 @@ func main() {
 		log.Fatalf("Error loading .env file")
 	}
 
-	// Initialize Redis
-	config.SetupRedis()
+	// Maintenance subcommands, e.g. "reconcile -repair"
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("%s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	store := setupStore()
+	controllers.SetRecordStore(store)
+	apiKeys := setupAPIKeyStore()
+	controllers.SetAPIKeyStore(apiKeys)
 
 	// Initialize RabbitMQ
 	config.SetupRabbitMQ()
 
-	// Initialize Elasticsearch and create the "plans" index
+	// Initialize Elasticsearch and create the "plans" alias and its index
 	config.SetupElasticsearch()
 	if err := services.CreateIndexIfNotExists(indexName); err != nil {
 		log.Fatalf("Failed to create Elasticsearch index: %v", err)
 	}
 
-	// Initialize Google JWT public certificates for token validation
-	config.InitGoogleCerts()
+	// Load the trusted JWT issuers and their signing keys, and keep them fresh
+	config.InitIssuers()
+	config.RunJWKSRefresh(context.Background())
+
+	// Map token claims to roles and permissions
+	config.InitAuthorization()
 
 	// Initialize the Gin router
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 	r.SetTrustedProxies([]string{})
+	r.Use(middleware.RequestID())
+
+	// Probes stay outside authentication
+	routes.SetupHealthRoutes(r)
 
-	// Apply AuthMiddleware to secure all routes
-	r.Use(middleware.AuthMiddleware())
+	// Apply AuthMiddleware to secure all other routes
+	r.Use(middleware.AuthMiddleware(apiKeys))
 
 	// Set up routes
-	routes.SetupRoutes(r)
+	routes.SetupRoutes(r, setupIdempotencyStore())
+
+	// Relay outbox events written with each record change to RabbitMQ
+	relayConsumer, err := os.Hostname()
+	if err != nil {
+		relayConsumer = "relay"
+	}
+	go services.RunOutboxRelay(context.Background(), store, relayConsumer)
+
+	// Periodically compare Redis with Elasticsearch, e.g. RECONCILE_INTERVAL=1h
+	if interval := os.Getenv("RECONCILE_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid RECONCILE_INTERVAL: %v", err)
+		}
+		repair := os.Getenv("RECONCILE_REPAIR") == "true"
+		go services.RunReconcileSchedule(context.Background(), store, indexName, d, repair)
+	}
+
+	// Periodically remove plans deleted longer than DELETE_RETENTION ago,
+	// e.g. PURGE_INTERVAL=24h
+	if interval := os.Getenv("PURGE_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid PURGE_INTERVAL: %v", err)
+		}
+		go services.RunPurgeSchedule(context.Background(), store, d)
+	}
 
 	// Start RabbitMQ consumer in a separate goroutine
 	go func() {
@@ -67,3 +118,35 @@ func main() {
 		fmt.Printf("Failed to start server: %v\n", err)
 	}
 }
+
+// setupStore initializes the record store; STORE_BACKEND=memory runs without
+// Redis.
+func setupStore() services.RecordStore {
+	if os.Getenv("STORE_BACKEND") == "memory" {
+		return services.NewMemoryRecordStore()
+	}
+	config.SetupRedis()
+	store := services.NewRedisRecordStore(config.RedisClient, recordsKey)
+	if err := store.EnsureOrgIndex(); err != nil {
+		log.Fatalf("Failed to index records by org: %v", err)
+	}
+	return store
+}
+
+// setupAPIKeyStore initializes the API key store next to the record store
+// set up by setupStore.
+func setupAPIKeyStore() services.APIKeyStore {
+	if os.Getenv("STORE_BACKEND") == "memory" {
+		return services.NewMemoryAPIKeyStore()
+	}
+	return services.NewRedisAPIKeyStore(config.RedisClient, apiKeysKey)
+}
+
+// setupIdempotencyStore initializes the Idempotency-Key store next to the
+// record store set up by setupStore.
+func setupIdempotencyStore() services.IdempotencyStore {
+	if os.Getenv("STORE_BACKEND") == "memory" {
+		return services.NewMemoryIdempotencyStore()
+	}
+	return services.NewRedisIdempotencyStore(config.RedisClient, idempotencyKey)
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"csye7255-project-one/config"
+	"csye7255-project-one/services"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -9,9 +11,17 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware accepts a bearer JWT from a trusted issuer, stored as its
+// claims under "user", or an API key sent as "Authorization: ApiKey <key>" or
+// in X-API-Key, stored under "apiKey".
+func AuthMiddleware(apiKeys services.APIKeyStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if apiKey := presentedAPIKey(c, authHeader); apiKey != "" {
+			authenticateAPIKey(c, apiKeys, apiKey)
+			return
+		}
+
 		if !strings.HasPrefix(authHeader, "Bearer ") {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing or invalid"})
 			c.Abort()
@@ -19,15 +29,9 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := config.ValidateGoogleToken(tokenString)
+		token, err := config.ValidateToken(tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
-
-		if err := config.ValidateTokenAudience(token); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
 			c.Abort()
 			return
 		}
@@ -41,3 +45,27 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 	}
 }
+
+func presentedAPIKey(c *gin.Context, authHeader string) string {
+	if key, ok := strings.CutPrefix(authHeader, "ApiKey "); ok {
+		return key
+	}
+	return c.GetHeader("X-API-Key")
+}
+
+func authenticateAPIKey(c *gin.Context, apiKeys services.APIKeyStore, plaintext string) {
+	key, err := services.AuthenticateAPIKey(apiKeys, plaintext)
+	if errors.Is(err, services.ErrInvalidAPIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify API key"})
+		c.Abort()
+		return
+	}
+
+	c.Set("apiKey", key.Public())
+	c.Set("orgs", key.Orgs)
+	c.Next()
+}
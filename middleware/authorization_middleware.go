@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"csye7255-project-one/config"
+	"csye7255-project-one/services"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RequirePermission rejects callers that are not granted permission: JWT
+// callers through the roles mapped from their claims, API keys through their
+// scopes. It runs after AuthMiddleware and stores a JWT caller's roles under
+// "roles" and the orgs they are bound to under "orgs".
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if permitted, reason := checkPermission(c, permission); !permitted {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Permission %s required: %s", permission, reason)})
+			c.Abort()
+			return
+		}
+		if len(c.GetStringSlice("orgs")) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Caller is not bound to any _org"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkPermission reports whether the caller is granted permission, and why
+// not when it is not.
+func checkPermission(c *gin.Context, permission string) (bool, string) {
+	if value, ok := c.Get("apiKey"); ok {
+		key := value.(services.APIKey)
+		for _, scope := range key.Scopes {
+			if scope == permission {
+				return true, ""
+			}
+		}
+		return false, "API key scopes " + strings.Join(key.Scopes, ", ") + " do not grant it"
+	}
+
+	roles := callerRoles(c)
+	if config.Authorization.Permits(roles, permission) {
+		return true, ""
+	}
+	if len(roles) == 0 {
+		return false, "no roles granted"
+	}
+	return false, "roles " + strings.Join(roles, ", ") + " do not grant it"
+}
+
+func callerRoles(c *gin.Context) []string {
+	if roles, ok := c.Get("roles"); ok {
+		return roles.([]string)
+	}
+
+	claims, _ := c.Get("user")
+	mapClaims, _ := claims.(jwt.MapClaims)
+	roles := config.Authorization.RolesFor(mapClaims)
+	c.Set("roles", roles)
+	c.Set("orgs", config.Authorization.OrgsFor(mapClaims))
+	return roles
+}
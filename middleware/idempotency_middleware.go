@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"csye7255-project-one/services"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+)
+
+// Response headers kept with an idempotent response and replayed with it.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location", "Content-Location"}
+
+// Idempotency makes requests carrying an Idempotency-Key safe to retry. The
+// first response for a key is stored for services.IdempotencyTTL and replayed
+// to later requests with the same key; reusing a key for a different request
+// is rejected with 422. Keys are scoped to the caller. Server errors are not
+// stored, so those requests can be retried with the same key.
+func Idempotency(store services.IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scopedKey := idempotencyCaller(c) + ":" + key
+		fingerprint := requestFingerprint(c, body)
+		ttl := services.IdempotencyTTL()
+
+		existing, claimed, err := store.BeginRequest(scopedKey, fingerprint, services.IdempotencyLockTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key"})
+			c.Abort()
+			return
+		}
+		if !claimed {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case !existing.Completed:
+				c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+			default:
+				replayResponse(c, existing)
+			}
+			c.Abort()
+			return
+		}
+
+		// Release the claim unless a response was stored, also when the
+		// handler panics
+		stored := false
+		defer func() {
+			if stored {
+				return
+			}
+			if err := store.ReleaseRequest(scopedKey, fingerprint); err != nil {
+				log.Printf("Failed to release Idempotency-Key %s: %v", key, err)
+			}
+		}()
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		status := c.Writer.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+
+		header := map[string]string{}
+		for _, name := range replayedHeaders {
+			if value := c.Writer.Header().Get(name); value != "" {
+				header[name] = value
+			}
+		}
+		response := services.IdempotentRequest{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Header:      header,
+			Body:        writer.body.Bytes(),
+		}
+		if err := store.CompleteRequest(scopedKey, response, ttl); err != nil {
+			log.Printf("Failed to store the response for Idempotency-Key %s: %v", key, err)
+			return
+		}
+		stored = true
+	}
+}
+
+// idempotencyCaller identifies the caller a key belongs to.
+func idempotencyCaller(c *gin.Context) string {
+	if value, ok := c.Get("apiKey"); ok {
+		return "apikey:" + value.(services.APIKey).ID
+	}
+	value, _ := c.Get("user")
+	claims, _ := value.(jwt.MapClaims)
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	return "jwt:" + issuer + ":" + subject
+}
+
+// requestFingerprint hashes the method, path, Content-Type, If-Match and body
+// of a request, so a retry with a different precondition is a different
+// request.
+func requestFingerprint(c *gin.Context, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+	hash.Write([]byte(c.GetHeader("Content-Type") + "\n"))
+	hash.Write([]byte(c.GetHeader("If-Match") + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replayResponse(c *gin.Context, response *services.IdempotentRequest) {
+	for name, value := range response.Header {
+		c.Header(name, value)
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Status(response.Status)
+	if len(response.Body) > 0 {
+		c.Writer.Write(response.Body)
+	}
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
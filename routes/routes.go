@@ -1,21 +1,73 @@
 package routes
 
 import (
+	"csye7255-project-one/config"
 	"csye7255-project-one/controllers"
+	"csye7255-project-one/middleware"
+	"csye7255-project-one/services"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(router *gin.Engine) {
+// SetupHealthRoutes registers the probes, which must be set up before the
+// auth middleware so they stay unauthenticated.
+func SetupHealthRoutes(router *gin.Engine) {
+	router.GET("/readyz", controllers.Readiness)
+}
+
+// SetupRoutes registers the API; every route declares the permission it
+// requires. Plan writes honor an Idempotency-Key backed by idempotencyStore.
+func SetupRoutes(router *gin.Engine, idempotencyStore services.IdempotencyStore) {
+	read := middleware.RequirePermission(config.PermissionPlansRead)
+	write := middleware.RequirePermission(config.PermissionPlansWrite)
+	remove := middleware.RequirePermission(config.PermissionPlansDelete)
+	idempotent := middleware.Idempotency(idempotencyStore)
+
 	v1 := router.Group("/v1")
 	{
 		plans := v1.Group("/plans")
 		{
-			plans.POST("/", controllers.CreateRecord)
-			plans.GET("/:id", controllers.GetRecord)
-			plans.DELETE("/:id", controllers.DeleteRecord)
-			plans.PATCH("/:id", controllers.PatchRecord)
-			plans.PUT("/:id", controllers.PutRecord)
+			plans.POST("/", write, idempotent, controllers.CreateRecord)
+			plans.GET("/", read, controllers.ListRecords)
+			plans.GET("/_search", read, controllers.SearchRecords)
+			plans.POST("/_search", read, controllers.SearchRecords)
+			plans.GET("/:id", read, controllers.GetRecord)
+			plans.DELETE("/:id", remove, idempotent, controllers.DeleteRecord)
+			plans.PATCH("/:id", write, idempotent, controllers.PatchRecord)
+			plans.PUT("/:id", write, idempotent, controllers.PutRecord)
+			plans.POST("/:id/restore", write, idempotent, controllers.RestoreRecord)
+			plans.GET("/:id/audit", read, controllers.GetAuditLog)
+			plans.GET("/:id/versions", read, controllers.ListVersions)
+			plans.GET("/:id/versions/:n", read, controllers.GetVersion)
+
+			plans.GET("/:id/linkedPlanServices", read, controllers.GetLinkedPlanServices)
+			plans.POST("/:id/linkedPlanServices", write, idempotent, controllers.CreateLinkedPlanService)
+			plans.GET("/:id/linkedPlanServices/:serviceId", read, controllers.GetLinkedPlanService)
+			plans.PUT("/:id/linkedPlanServices/:serviceId", write, idempotent, controllers.PutLinkedPlanService)
+			plans.PATCH("/:id/linkedPlanServices/:serviceId", write, idempotent, controllers.PatchLinkedPlanService)
+			plans.DELETE("/:id/linkedPlanServices/:serviceId", write, idempotent, controllers.DeleteLinkedPlanService)
+
+			plans.GET("/:id/planCostShares", read, controllers.GetPlanCostShares)
+			plans.PUT("/:id/planCostShares", write, idempotent, controllers.PutPlanCostShares)
+			plans.PATCH("/:id/planCostShares", write, idempotent, controllers.PatchPlanCostShares)
+		}
+
+		analytics := v1.Group("/analytics")
+		{
+			analytics.GET("/deductibles", read, controllers.GetDeductibleAnalytics)
+			analytics.GET("/copays", read, controllers.GetCopayAnalytics)
+			analytics.GET("/plan-counts", read, controllers.GetPlanCountAnalytics)
+		}
+
+		admin := v1.Group("/admin", middleware.RequirePermission(config.PermissionAdmin))
+		{
+			admin.POST("/rebuild", controllers.StartRebuild)
+			admin.GET("/rebuild", controllers.GetRebuildStatus)
+
+			admin.POST("/api-keys", controllers.CreateAPIKey)
+			admin.GET("/api-keys", controllers.ListAPIKeys)
+			admin.POST("/api-keys/:keyId/rotate", controllers.RotateAPIKey)
+			admin.DELETE("/api-keys/:keyId", controllers.RevokeAPIKey)
 		}
 	}
 }
@@ -0,0 +1,434 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"csye7255-project-one/config"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+const (
+	defaultAnalyticsBuckets = 20
+	maxAnalyticsBuckets     = 100
+	defaultCopayInterval    = 10
+)
+
+var ErrInvalidAnalyticsQuery = errors.New("invalid analytics query")
+
+// calendarIntervals are the time buckets accepted on creationDate.
+var calendarIntervals = map[string]bool{
+	"day":     true,
+	"week":    true,
+	"month":   true,
+	"quarter": true,
+	"year":    true,
+}
+
+// AnalyticsFilter restricts a report to plans matching all set fields.
+// CreatedFrom and CreatedTo are inclusive MM-DD-YYYY bounds on creationDate.
+type AnalyticsFilter struct {
+	Org         string
+	PlanType    string
+	CreatedFrom string
+	CreatedTo   string
+	Size        int
+	// Orgs restricts the report to plans of the caller's orgs.
+	Orgs []string
+}
+
+type DeductibleGroup struct {
+	PlanType          string   `json:"planType"`
+	Plans             int64    `json:"plans"`
+	AverageDeductible *float64 `json:"averageDeductible"`
+	AverageCopay      *float64 `json:"averageCopay"`
+}
+
+type DeductibleReport struct {
+	Plans  int64             `json:"plans"`
+	Groups []DeductibleGroup `json:"groups"`
+}
+
+type CopayBucket struct {
+	From     float64 `json:"from"`
+	Services int64   `json:"services"`
+}
+
+type CopayGroup struct {
+	Name         string        `json:"name"`
+	Services     int64         `json:"services"`
+	MinCopay     *float64      `json:"minCopay"`
+	MaxCopay     *float64      `json:"maxCopay"`
+	AverageCopay *float64      `json:"averageCopay"`
+	Distribution []CopayBucket `json:"distribution"`
+}
+
+type CopayReport struct {
+	Interval int          `json:"interval"`
+	Groups   []CopayGroup `json:"groups"`
+}
+
+type PeriodCount struct {
+	Period string `json:"period"`
+	Plans  int64  `json:"plans"`
+}
+
+type OrgPlanCounts struct {
+	Org     string        `json:"_org"`
+	Plans   int64         `json:"plans"`
+	Periods []PeriodCount `json:"periods"`
+}
+
+type PlanCountReport struct {
+	Interval string          `json:"interval"`
+	Orgs     []OrgPlanCounts `json:"orgs"`
+}
+
+type termsBucket struct {
+	Key      string `json:"key"`
+	DocCount int64  `json:"doc_count"`
+}
+
+type metricValue struct {
+	Value *float64 `json:"value"`
+}
+
+// AverageDeductibles groups plans by planType and averages the deductible and
+// copay of their planCostShares children.
+func AverageDeductibles(index string, filter AnalyticsFilter) (*DeductibleReport, error) {
+	query, size, err := planFilterQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	aggs := map[string]interface{}{
+		"planTypes": map[string]interface{}{
+			"terms": stableTerms("planType.keyword", size),
+			"aggs": map[string]interface{}{
+				"costShares": map[string]interface{}{
+					"children": map[string]interface{}{"type": "planCostShares"},
+					"aggs": map[string]interface{}{
+						"deductible": avgAggregation("deductible"),
+						"copay":      avgAggregation("copay"),
+					},
+				},
+			},
+		},
+	}
+
+	var response struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+		} `json:"hits"`
+		Aggregations struct {
+			PlanTypes struct {
+				Buckets []struct {
+					termsBucket
+					CostShares struct {
+						Deductible metricValue `json:"deductible"`
+						Copay      metricValue `json:"copay"`
+					} `json:"costShares"`
+				} `json:"buckets"`
+			} `json:"planTypes"`
+		} `json:"aggregations"`
+	}
+	if err := aggregate(index, query, aggs, &response); err != nil {
+		return nil, err
+	}
+
+	report := &DeductibleReport{Plans: response.Hits.Total.Value, Groups: []DeductibleGroup{}}
+	for _, bucket := range response.Aggregations.PlanTypes.Buckets {
+		report.Groups = append(report.Groups, DeductibleGroup{
+			PlanType:          bucket.Key,
+			Plans:             bucket.DocCount,
+			AverageDeductible: bucket.CostShares.Deductible.Value,
+			AverageCopay:      bucket.CostShares.Copay.Value,
+		})
+	}
+	return report, nil
+}
+
+// CopayDistribution groups the linkedPlanServices of matching plans by the
+// name of their linkedService and buckets the copay of the sibling
+// planServiceCostShares. The sibling is reached through a parent aggregation
+// back to linkedPlanServices followed by a children aggregation.
+func CopayDistribution(index string, filter AnalyticsFilter, interval int) (*CopayReport, error) {
+	planQuery, size, err := planFilterQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+	if interval == 0 {
+		interval = defaultCopayInterval
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("%w: interval must be positive", ErrInvalidAnalyticsQuery)
+	}
+
+	query := map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": []interface{}{
+				relationTerm("linkedPlanServices"),
+				map[string]interface{}{
+					"has_parent": map[string]interface{}{
+						"parent_type": "plan",
+						"query":       planQuery,
+					},
+				},
+			},
+		},
+	}
+
+	aggs := map[string]interface{}{
+		"services": map[string]interface{}{
+			"children": map[string]interface{}{"type": "linkedService"},
+			"aggs": map[string]interface{}{
+				"names": map[string]interface{}{
+					"terms": stableTerms("name.keyword", size),
+					"aggs": map[string]interface{}{
+						"linkedPlanServices": map[string]interface{}{
+							"parent": map[string]interface{}{"type": "linkedService"},
+							"aggs": map[string]interface{}{
+								"costShares": map[string]interface{}{
+									"children": map[string]interface{}{"type": "planServiceCostShares"},
+									"aggs": map[string]interface{}{
+										"copay": map[string]interface{}{
+											"stats": map[string]interface{}{"field": "copay"},
+										},
+										"distribution": map[string]interface{}{
+											"histogram": map[string]interface{}{
+												"field":    "copay",
+												"interval": interval,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var response struct {
+		Aggregations struct {
+			Services struct {
+				Names struct {
+					Buckets []struct {
+						termsBucket
+						LinkedPlanServices struct {
+							CostShares struct {
+								Copay struct {
+									Min *float64 `json:"min"`
+									Max *float64 `json:"max"`
+									Avg *float64 `json:"avg"`
+								} `json:"copay"`
+								Distribution struct {
+									Buckets []struct {
+										Key      float64 `json:"key"`
+										DocCount int64   `json:"doc_count"`
+									} `json:"buckets"`
+								} `json:"distribution"`
+							} `json:"costShares"`
+						} `json:"linkedPlanServices"`
+					} `json:"buckets"`
+				} `json:"names"`
+			} `json:"services"`
+		} `json:"aggregations"`
+	}
+	if err := aggregate(index, query, aggs, &response); err != nil {
+		return nil, err
+	}
+
+	report := &CopayReport{Interval: interval, Groups: []CopayGroup{}}
+	for _, bucket := range response.Aggregations.Services.Names.Buckets {
+		costShares := bucket.LinkedPlanServices.CostShares
+		group := CopayGroup{
+			Name:         bucket.Key,
+			Services:     bucket.DocCount,
+			MinCopay:     costShares.Copay.Min,
+			MaxCopay:     costShares.Copay.Max,
+			AverageCopay: costShares.Copay.Avg,
+			Distribution: []CopayBucket{},
+		}
+		for _, histogramBucket := range costShares.Distribution.Buckets {
+			group.Distribution = append(group.Distribution, CopayBucket{From: histogramBucket.Key, Services: histogramBucket.DocCount})
+		}
+		report.Groups = append(report.Groups, group)
+	}
+	return report, nil
+}
+
+// PlanCounts counts matching plans per _org in calendar buckets of
+// creationDate.
+func PlanCounts(index string, filter AnalyticsFilter, interval string) (*PlanCountReport, error) {
+	query, size, err := planFilterQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+	if interval == "" {
+		interval = "month"
+	}
+	if !calendarIntervals[interval] {
+		return nil, fmt.Errorf("%w: interval must be one of day, week, month, quarter or year", ErrInvalidAnalyticsQuery)
+	}
+
+	aggs := map[string]interface{}{
+		"orgs": map[string]interface{}{
+			"terms": stableTerms("_org.keyword", size),
+			"aggs": map[string]interface{}{
+				"periods": map[string]interface{}{
+					"date_histogram": map[string]interface{}{
+						"field":             "creationDate",
+						"calendar_interval": interval,
+						"format":            "MM-dd-yyyy",
+					},
+				},
+			},
+		},
+	}
+
+	var response struct {
+		Aggregations struct {
+			Orgs struct {
+				Buckets []struct {
+					termsBucket
+					Periods struct {
+						Buckets []struct {
+							KeyAsString string `json:"key_as_string"`
+							DocCount    int64  `json:"doc_count"`
+						} `json:"buckets"`
+					} `json:"periods"`
+				} `json:"buckets"`
+			} `json:"orgs"`
+		} `json:"aggregations"`
+	}
+	if err := aggregate(index, query, aggs, &response); err != nil {
+		return nil, err
+	}
+
+	report := &PlanCountReport{Interval: interval, Orgs: []OrgPlanCounts{}}
+	for _, bucket := range response.Aggregations.Orgs.Buckets {
+		counts := OrgPlanCounts{Org: bucket.Key, Plans: bucket.DocCount, Periods: []PeriodCount{}}
+		for _, period := range bucket.Periods.Buckets {
+			counts.Periods = append(counts.Periods, PeriodCount{Period: period.KeyAsString, Plans: period.DocCount})
+		}
+		report.Orgs = append(report.Orgs, counts)
+	}
+	return report, nil
+}
+
+// planFilterQuery validates filter and returns a query over plan documents
+// along with the number of buckets to return per grouping.
+func planFilterQuery(filter AnalyticsFilter) (map[string]interface{}, int, error) {
+	size := filter.Size
+	if size == 0 {
+		size = defaultAnalyticsBuckets
+	}
+	if size < 0 || size > maxAnalyticsBuckets {
+		return nil, 0, fmt.Errorf("%w: size must be between 1 and %d", ErrInvalidAnalyticsQuery, maxAnalyticsBuckets)
+	}
+
+	filters := []interface{}{relationTerm("plan")}
+	if orgFilter := orgTermsFilter(filter.Orgs); orgFilter != nil {
+		filters = append(filters, orgFilter)
+	}
+	if filter.Org != "" {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"_org.keyword": filter.Org},
+		})
+	}
+	if filter.PlanType != "" {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"planType.keyword": filter.PlanType},
+		})
+	}
+
+	if filter.CreatedFrom != "" || filter.CreatedTo != "" {
+		r := RangeFilter{}
+		if filter.CreatedFrom != "" {
+			if !validDateBound(filter.CreatedFrom) {
+				return nil, 0, fmt.Errorf("%w: from must be a date in MM-DD-YYYY format", ErrInvalidAnalyticsQuery)
+			}
+			r.Gte = filter.CreatedFrom
+		}
+		if filter.CreatedTo != "" {
+			if !validDateBound(filter.CreatedTo) {
+				return nil, 0, fmt.Errorf("%w: to must be a date in MM-DD-YYYY format", ErrInvalidAnalyticsQuery)
+			}
+			r.Lte = filter.CreatedTo
+		}
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{"creationDate": r},
+		})
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{"filter": filters},
+	}, size, nil
+}
+
+// stableTerms orders buckets by count and then by key, so equal counts come
+// back in the same order on every request.
+func stableTerms(field string, size int) map[string]interface{} {
+	return map[string]interface{}{
+		"field": field,
+		"size":  size,
+		"order": []interface{}{
+			map[string]interface{}{"_count": "desc"},
+			map[string]interface{}{"_key": "asc"},
+		},
+	}
+}
+
+func avgAggregation(field string) map[string]interface{} {
+	return map[string]interface{}{
+		"avg": map[string]interface{}{"field": field},
+	}
+}
+
+// aggregate runs query with aggs against index and decodes the response into
+// result.
+func aggregate(index string, query, aggs map[string]interface{}, result interface{}) error {
+	if config.ESClient == nil {
+		return errors.New("elasticsearch client is not initialized")
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query": query,
+		"aggs":  aggs,
+		"size":  0,
+		// Plan counts must be exact, not capped at 10,000
+		"track_total_hits": true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal aggregation: %v", err)
+	}
+
+	req := esapi.SearchRequest{
+		Index:             []string{index},
+		Body:              bytes.NewReader(body),
+		IgnoreUnavailable: &ignoreUnavailable,
+		AllowNoIndices:    &allowNoIndices,
+	}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return fmt.Errorf("failed to run aggregation: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to run aggregation: %s", res.String())
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse aggregation results: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"csye7255-project-one/config"
+)
+
+const (
+	apiKeyPrefix = "pk"
+
+	// Uses are recorded at most this often per key, so authenticating does
+	// not cost a write on every request.
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey        = errors.New("invalid API key")
+	ErrAPIKeyNotFound       = errors.New("API key not found")
+	ErrInvalidAPIKeyRequest = errors.New("invalid API key request")
+)
+
+// APIKey authenticates a service-to-service caller. The secret is only ever
+// returned when the key is created or rotated; the store keeps its SHA-256.
+// Scopes are the permissions the key grants and Orgs the orgs it is bound to.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	SecretHash string     `json:"secretHash,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	Orgs       []string   `json:"orgs"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RotatedAt  *time.Time `json:"rotatedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// Public returns the key without its secret hash.
+func (k APIKey) Public() APIKey {
+	k.SecretHash = ""
+	return k
+}
+
+// CreateAPIKey stores a new key and returns it along with the plaintext key
+// the caller presents, "pk_<id>_<secret>".
+func CreateAPIKey(store APIKeyStore, name string, scopes, orgs []string, expiresAt *time.Time) (*APIKey, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: name is required", ErrInvalidAPIKeyRequest)
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKeyRequest)
+	}
+	for _, scope := range scopes {
+		if !config.IsPermission(scope) {
+			return nil, "", fmt.Errorf("%w: unknown scope %s", ErrInvalidAPIKeyRequest, scope)
+		}
+	}
+	if len(orgs) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one org is required", ErrInvalidAPIKeyRequest)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expiresAt must be in the future", ErrInvalidAPIKeyRequest)
+	}
+
+	id, err := randomToken(8)
+	if err != nil {
+		return nil, "", err
+	}
+	key := APIKey{
+		ID:        id,
+		Name:      name,
+		Scopes:    scopes,
+		Orgs:      orgs,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+	plaintext, err := issueSecret(&key)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := store.SaveAPIKey(key); err != nil {
+		return nil, "", err
+	}
+	return &key, plaintext, nil
+}
+
+// RotateAPIKey replaces the secret of a key; the previous secret stops
+// working immediately. A key revoked meanwhile is not brought back.
+func RotateAPIKey(store APIKeyStore, id string) (*APIKey, string, error) {
+	var plaintext string
+	key, err := store.UpdateAPIKey(id, func(key *APIKey) error {
+		if key.RevokedAt != nil {
+			return ErrAPIKeyNotFound
+		}
+		secret, err := issueSecret(key)
+		if err != nil {
+			return err
+		}
+		plaintext = secret
+		now := time.Now().UTC()
+		key.RotatedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return key, plaintext, nil
+}
+
+// RevokeAPIKey disables a key for good. Revoked keys stay listed.
+func RevokeAPIKey(store APIKeyStore, id string) error {
+	_, err := store.UpdateAPIKey(id, func(key *APIKey) error {
+		if key.RevokedAt == nil {
+			now := time.Now().UTC()
+			key.RevokedAt = &now
+		}
+		return nil
+	})
+	return err
+}
+
+// AuthenticateAPIKey returns the key a plaintext API key belongs to, or
+// ErrInvalidAPIKey when it is unknown, revoked, expired or has the wrong
+// secret.
+func AuthenticateAPIKey(store APIKeyStore, plaintext string) (*APIKey, error) {
+	prefix, rest, ok := strings.Cut(plaintext, "_")
+	if !ok || prefix != apiKeyPrefix {
+		return nil, ErrInvalidAPIKey
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := store.GetAPIKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := store.TouchAPIKey(key.ID, now); err != nil {
+			log.Printf("Failed to record use of API key %s: %v", key.ID, err)
+		}
+	}
+	return key, nil
+}
+
+// issueSecret gives key a new random secret and returns the plaintext key.
+func issueSecret(key *APIKey) (string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	key.SecretHash = hashSecret(secret)
+	return fmt.Sprintf("%s_%s_%s", apiKeyPrefix, key.ID, secret), nil
+}
+
+// hashSecret hashes a secret for storage. The secrets are random, so a fast
+// hash is as good as a password hash here.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes encoded without "_", so the parts of a
+// plaintext key split unambiguously.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %v", err)
+	}
+	return strings.ReplaceAll(base64.RawURLEncoding.EncodeToString(b), "_", "-"), nil
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"csye7255-project-one/config"
+)
+
+func createTestAPIKey(t *testing.T, store *MemoryAPIKeyStore, orgs ...string) (*APIKey, string) {
+	t.Helper()
+	key, plaintext, err := CreateAPIKey(store, "billing", []string{config.PermissionPlansRead}, orgs, nil)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	return key, plaintext
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup creates the keys under test and returns the plaintext key to
+		// present.
+		setup   func(t *testing.T, store *MemoryAPIKeyStore) string
+		wantErr error
+	}{
+		{
+			name: "valid key",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				_, plaintext := createTestAPIKey(t, store, "example.com", "acme")
+				return plaintext
+			},
+		},
+		{
+			name: "wrong secret",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				key, _ := createTestAPIKey(t, store, "example.com", "acme")
+				return apiKeyPrefix + "_" + key.ID + "_guessed"
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "secret of another key",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				key, _ := createTestAPIKey(t, store, "example.com", "acme")
+				_, other := createTestAPIKey(t, store, "other.com")
+				return apiKeyPrefix + "_" + key.ID + "_" + other[strings.LastIndex(other, "_")+1:]
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "unknown id",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				return apiKeyPrefix + "_missing_secret"
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "wrong prefix",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				_, plaintext := createTestAPIKey(t, store, "example.com", "acme")
+				return "sk" + strings.TrimPrefix(plaintext, apiKeyPrefix)
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "malformed",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				return apiKeyPrefix + "_nosecret"
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "revoked",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				key, plaintext := createTestAPIKey(t, store, "example.com", "acme")
+				if err := RevokeAPIKey(store, key.ID); err != nil {
+					t.Fatalf("revoke: %v", err)
+				}
+				return plaintext
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "expired",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				key, plaintext := createTestAPIKey(t, store, "example.com", "acme")
+				_, err := store.UpdateAPIKey(key.ID, func(key *APIKey) error {
+					expired := time.Now().Add(-time.Second)
+					key.ExpiresAt = &expired
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("expire: %v", err)
+				}
+				return plaintext
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "not yet expired",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				expiresAt := time.Now().Add(time.Hour)
+				_, plaintext, err := CreateAPIKey(store, "billing", []string{config.PermissionPlansRead}, []string{"example.com", "acme"}, &expiresAt)
+				if err != nil {
+					t.Fatalf("create key: %v", err)
+				}
+				return plaintext
+			},
+		},
+		{
+			name: "secret replaced by rotation",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				key, plaintext := createTestAPIKey(t, store, "example.com", "acme")
+				if _, _, err := RotateAPIKey(store, key.ID); err != nil {
+					t.Fatalf("rotate: %v", err)
+				}
+				return plaintext
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "rotated secret",
+			setup: func(t *testing.T, store *MemoryAPIKeyStore) string {
+				key, _ := createTestAPIKey(t, store, "example.com", "acme")
+				_, plaintext, err := RotateAPIKey(store, key.ID)
+				if err != nil {
+					t.Fatalf("rotate: %v", err)
+				}
+				return plaintext
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryAPIKeyStore()
+			plaintext := tt.setup(t, store)
+
+			key, err := AuthenticateAPIKey(store, plaintext)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if want := []string{"example.com", "acme"}; !reflect.DeepEqual(key.Orgs, want) {
+				t.Errorf("orgs = %v, want the bound orgs %v", key.Orgs, want)
+			}
+			if stored, _ := store.GetAPIKey(key.ID); stored.LastUsedAt == nil {
+				t.Error("use was not recorded")
+			}
+		})
+	}
+}
+
+func TestCreateAPIKeyStoresHash(t *testing.T) {
+	store := NewMemoryAPIKeyStore()
+	key, plaintext := createTestAPIKey(t, store, "example.com")
+
+	secret := plaintext[strings.LastIndex(plaintext, "_")+1:]
+	if !strings.HasPrefix(plaintext, apiKeyPrefix+"_"+key.ID+"_") {
+		t.Errorf("plaintext %q does not name key %s", plaintext, key.ID)
+	}
+	stored, err := store.GetAPIKey(key.ID)
+	if err != nil || stored == nil {
+		t.Fatalf("get key: %v, %v", stored, err)
+	}
+	if stored.SecretHash != hashSecret(secret) || strings.Contains(stored.SecretHash, secret) {
+		t.Errorf("stored secret hash %q, want the SHA-256 of the secret", stored.SecretHash)
+	}
+	if stored.Public().SecretHash != "" {
+		t.Error("Public kept the secret hash")
+	}
+}
+
+func TestCreateAPIKeyRejectsInvalid(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name      string
+		keyName   string
+		scopes    []string
+		orgs      []string
+		expiresAt *time.Time
+	}{
+		{"no name", "", []string{config.PermissionPlansRead}, []string{"example.com"}, nil},
+		{"no scopes", "billing", nil, []string{"example.com"}, nil},
+		{"unknown scope", "billing", []string{"plans:own"}, []string{"example.com"}, nil},
+		{"no orgs", "billing", []string{config.PermissionPlansRead}, nil, nil},
+		{"expired", "billing", []string{config.PermissionPlansRead}, []string{"example.com"}, &past},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryAPIKeyStore()
+			if _, _, err := CreateAPIKey(store, tt.keyName, tt.scopes, tt.orgs, tt.expiresAt); !errors.Is(err, ErrInvalidAPIKeyRequest) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidAPIKeyRequest)
+			}
+			if keys, _ := store.ListAPIKeys(); len(keys) != 0 {
+				t.Errorf("stored %d keys", len(keys))
+			}
+		})
+	}
+}
+
+func TestMemoryAPIKeyStore(t *testing.T) {
+	store := NewMemoryAPIKeyStore()
+	key, _ := createTestAPIKey(t, store, "example.com")
+
+	if missing, err := store.GetAPIKey("missing"); missing != nil || err != nil {
+		t.Errorf("unknown id: got %v, %v, want nil", missing, err)
+	}
+	if _, err := store.UpdateAPIKey("missing", func(*APIKey) error { return nil }); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("update unknown id: got error %v, want %v", err, ErrAPIKeyNotFound)
+	}
+	if _, _, err := RotateAPIKey(store, "missing"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("rotate unknown id: got error %v, want %v", err, ErrAPIKeyNotFound)
+	}
+
+	// A use is kept apart from the key, so revoking does not drop it
+	used := time.Now().UTC().Truncate(time.Second)
+	if err := store.TouchAPIKey(key.ID, used); err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	if err := RevokeAPIKey(store, key.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	stored, _ := store.GetAPIKey(key.ID)
+	if stored.RevokedAt == nil || stored.LastUsedAt == nil || !stored.LastUsedAt.Equal(used) {
+		t.Errorf("got revokedAt %v and lastUsedAt %v, want revoked and used at %v", stored.RevokedAt, stored.LastUsedAt, used)
+	}
+	if _, _, err := RotateAPIKey(store, key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("rotate revoked key: got error %v, want %v", err, ErrAPIKeyNotFound)
+	}
+	if keys, _ := store.ListAPIKeys(); len(keys) != 1 || keys[0].ID != key.ID {
+		t.Errorf("list = %v, want the revoked key", keys)
+	}
+}
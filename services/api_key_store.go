@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// APIKeyStore persists API keys by ID. Last-used timestamps are kept apart
+// from the key itself, so recording a use never overwrites a concurrent
+// rotation or revocation. GetAPIKey returns nil when the ID does not exist.
+// UpdateAPIKey applies update to the stored key atomically, so overlapping
+// updates never overwrite each other; it fails with ErrAPIKeyNotFound when the
+// ID does not exist and with the error of update when that rejects the key.
+type APIKeyStore interface {
+	SaveAPIKey(key APIKey) error
+	GetAPIKey(id string) (*APIKey, error)
+	UpdateAPIKey(id string, update func(key *APIKey) error) (*APIKey, error)
+	ListAPIKeys() ([]APIKey, error)
+	TouchAPIKey(id string, at time.Time) error
+}
+
+// RedisAPIKeyStore keeps every key as a JSON field of one hash and the
+// last-used timestamps in a second hash.
+type RedisAPIKeyStore struct {
+	client  *redis.Client
+	key     string
+	usedKey string
+}
+
+func NewRedisAPIKeyStore(client *redis.Client, key string) *RedisAPIKeyStore {
+	return &RedisAPIKeyStore{client: client, key: key, usedKey: key + ":last-used"}
+}
+
+func (s *RedisAPIKeyStore) SaveAPIKey(key APIKey) error {
+	key.LastUsedAt = nil
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), s.key, key.ID, data).Err()
+}
+
+func (s *RedisAPIKeyStore) GetAPIKey(id string) (*APIKey, error) {
+	ctx := context.Background()
+
+	var keyCmd, usedCmd *redis.StringCmd
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		keyCmd = pipe.HGet(ctx, s.key, id)
+		usedCmd = pipe.HGet(ctx, s.usedKey, id)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	data, err := keyCmd.Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return nil, err
+	}
+	if used, err := usedCmd.Result(); err == nil {
+		key.LastUsedAt = parseLastUsed(used)
+	}
+	return &key, nil
+}
+
+// UpdateAPIKey watches the hash of keys, so a concurrent change to any key
+// makes the update retry.
+func (s *RedisAPIKeyStore) UpdateAPIKey(id string, update func(key *APIKey) error) (*APIKey, error) {
+	ctx := context.Background()
+
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		var updated APIKey
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.HGet(ctx, s.key, id).Result()
+			if err == redis.Nil {
+				return ErrAPIKeyNotFound
+			} else if err != nil {
+				return err
+			}
+
+			updated = APIKey{}
+			if err := json.Unmarshal([]byte(data), &updated); err != nil {
+				return err
+			}
+			if err := update(&updated); err != nil {
+				return err
+			}
+			updated.LastUsedAt = nil
+			newData, err := json.Marshal(updated)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.HSet(ctx, s.key, id, newData)
+				return nil
+			})
+			return err
+		}, s.key)
+		if err == nil {
+			return &updated, nil
+		} else if err != redis.TxFailedErr {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("gave up updating API key %s after %d conflicting attempts", id, maxWriteAttempts)
+}
+
+func (s *RedisAPIKeyStore) ListAPIKeys() ([]APIKey, error) {
+	ctx := context.Background()
+	entries, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	used, err := s.client.HGetAll(ctx, s.usedKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, 0, len(entries))
+	for id, data := range entries {
+		var key APIKey
+		if err := json.Unmarshal([]byte(data), &key); err != nil {
+			continue
+		}
+		key.LastUsedAt = parseLastUsed(used[id])
+		keys = append(keys, key)
+	}
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+func (s *RedisAPIKeyStore) TouchAPIKey(id string, at time.Time) error {
+	return s.client.HSet(context.Background(), s.usedKey, id, at.UTC().Format(time.RFC3339)).Err()
+}
+
+// MemoryAPIKeyStore is an in-process APIKeyStore for tests and local
+// development.
+type MemoryAPIKeyStore struct {
+	mu       sync.RWMutex
+	keys     map[string]APIKey
+	lastUsed map[string]time.Time
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{
+		keys:     make(map[string]APIKey),
+		lastUsed: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryAPIKeyStore) SaveAPIKey(key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key.LastUsedAt = nil
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) GetAPIKey(id string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, nil
+	}
+	if used, ok := s.lastUsed[id]; ok {
+		key.LastUsedAt = &used
+	}
+	return &key, nil
+}
+
+func (s *MemoryAPIKeyStore) UpdateAPIKey(id string, update func(key *APIKey) error) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err := update(&key); err != nil {
+		return nil, err
+	}
+	key.LastUsedAt = nil
+	s.keys[id] = key
+	return &key, nil
+}
+
+func (s *MemoryAPIKeyStore) ListAPIKeys() ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for id, key := range s.keys {
+		if used, ok := s.lastUsed[id]; ok {
+			key.LastUsedAt = &used
+		}
+		keys = append(keys, key)
+	}
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+func (s *MemoryAPIKeyStore) TouchAPIKey(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed[id] = at.UTC()
+	return nil
+}
+
+func parseLastUsed(value string) *time.Time {
+	used, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &used
+}
+
+func sortAPIKeys(keys []APIKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
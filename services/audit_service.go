@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"csye7255-project-one/utils"
+)
+
+// AuditInfo describes who made a change and through which request. Passed in
+// WriteOptions, it makes the store append an AuditEntry to the record's audit
+// log in the same transaction as the change.
+type AuditInfo struct {
+	Operation string
+	// Resource names the sub-resource that was changed, if any.
+	Resource  string
+	Actor     Actor
+	RequestID string
+}
+
+// Actor identifies the caller behind a change: the subject and email of a
+// JWT, or the id and name of an API key.
+type Actor struct {
+	Subject    string `json:"sub,omitempty"`
+	Email      string `json:"email,omitempty"`
+	APIKeyID   string `json:"apiKeyId,omitempty"`
+	APIKeyName string `json:"apiKeyName,omitempty"`
+}
+
+// AuditEntry is one change of a record. ETags are those the API served for
+// the record before and after the change, empty where it did not exist.
+type AuditEntry struct {
+	ID         string                `json:"id"`
+	PlanID     string                `json:"planId"`
+	Org        string                `json:"_org,omitempty"`
+	Version    int64                 `json:"version"`
+	Operation  string                `json:"operation"`
+	Resource   string                `json:"resource,omitempty"`
+	Actor      Actor                 `json:"actor"`
+	RequestID  string                `json:"requestId,omitempty"`
+	Timestamp  time.Time             `json:"timestamp"`
+	BeforeETag string                `json:"beforeETag,omitempty"`
+	AfterETag  string                `json:"afterETag,omitempty"`
+	Diff       []utils.DiffOperation `json:"diff"`
+}
+
+// AuditPage is one page of an audit log, oldest entry first. NextCursor is
+// empty on the last page.
+type AuditPage struct {
+	Entries    []AuditEntry
+	NextCursor string
+}
+
+// newAuditEntry describes the change of record id from before to after, both
+// as stored and nil where the record did not exist.
+func newAuditEntry(id string, info *AuditInfo, version int64, committedAt time.Time, before, after []byte) (AuditEntry, error) {
+	beforeDoc, beforeETag, err := decodeForAudit(before)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	afterDoc, afterETag, err := decodeForAudit(after)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	org := recordOrg(after)
+	if org == "" {
+		org = recordOrg(before)
+	}
+
+	return AuditEntry{
+		PlanID:     id,
+		Org:        org,
+		Version:    version,
+		Operation:  info.Operation,
+		Resource:   info.Resource,
+		Actor:      info.Actor,
+		RequestID:  info.RequestID,
+		Timestamp:  committedAt,
+		BeforeETag: beforeETag,
+		AfterETag:  afterETag,
+		Diff:       utils.Diff(beforeDoc, afterDoc),
+	}, nil
+}
+
+// decodeForAudit decodes a stored record and computes its ETag the way the
+// record handlers do, from the record re-encoded with sorted keys.
+func decodeForAudit(data []byte) (interface{}, string, error) {
+	if len(data) == 0 {
+		return nil, "", nil
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, "", err
+	}
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return nil, "", err
+	}
+	return doc, utils.GenerateETag(normalized), nil
+}
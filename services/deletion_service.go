@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultDeleteRetention = 30 * 24 * time.Hour
+
+// Tombstone is what remains of a deleted record until it is purged. Version
+// is the version of the delete.
+type Tombstone struct {
+	DeletedAt time.Time       `json:"deletedAt"`
+	Version   int64           `json:"version"`
+	Org       string          `json:"_org,omitempty"`
+	Record    json.RawMessage `json:"record"`
+}
+
+// Expired reports whether the tombstone is past the retention window and may
+// no longer be restored.
+func (t Tombstone) Expired() bool {
+	return time.Since(t.DeletedAt) > DeleteRetention()
+}
+
+// DeleteRetention is how long deleted plans can be restored, set with
+// DELETE_RETENTION, e.g. "168h". It defaults to 30 days.
+func DeleteRetention() time.Duration {
+	if raw := os.Getenv("DELETE_RETENTION"); raw != "" {
+		if retention, err := time.ParseDuration(raw); err == nil && retention > 0 {
+			return retention
+		}
+		log.Printf("Invalid DELETE_RETENTION %q, using %s", raw, defaultDeleteRetention)
+	}
+	return defaultDeleteRetention
+}
+
+// PurgeExpired permanently removes the plans deleted longer than the retention
+// window ago.
+func PurgeExpired(store RecordStore) (int, error) {
+	return store.PurgeDeleted(time.Now().Add(-DeleteRetention()))
+}
+
+// RunPurgeSchedule runs PurgeExpired every interval until ctx is cancelled.
+func RunPurgeSchedule(ctx context.Context, store RecordStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := PurgeExpired(store)
+		if err != nil {
+			log.Printf("Purge of deleted plans failed: %v", err)
+			continue
+		}
+		log.Printf("Purged %d deleted plans", purged)
+	}
+}
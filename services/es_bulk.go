@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"csye7255-project-one/config"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// bulkAction is one line pair of a bulk request. Op is "index", "update" or
+// "delete"; update actions upsert Body as a partial document. A non-zero
+// Version is applied with version_type=external (not supported by update).
+type bulkAction struct {
+	Op      string
+	ID      string
+	Routing string
+	Version int64
+	Body    map[string]interface{}
+}
+
+// BulkItemFailure is a rejected item of a bulk request.
+type BulkItemFailure struct {
+	Op     string
+	ID     string
+	Status int
+	Type   string
+	Reason string
+}
+
+// BulkError reports the items of a bulk request that Elasticsearch rejected;
+// the other items were applied.
+type BulkError struct {
+	Failures []BulkItemFailure
+}
+
+func (e *BulkError) Error() string {
+	parts := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		parts = append(parts, fmt.Sprintf("%s %s: %d %s: %s", failure.Op, failure.ID, failure.Status, failure.Type, failure.Reason))
+	}
+	return fmt.Sprintf("%d bulk items failed: %s", len(e.Failures), strings.Join(parts, "; "))
+}
+
+// refreshPolicy is the refresh parameter for writes, set with ES_REFRESH to
+// "true", "false" or "wait_for". It defaults to "wait_for" so a write is
+// searchable when the request returns without forcing a refresh.
+func refreshPolicy() string {
+	if policy := os.Getenv("ES_REFRESH"); policy != "" {
+		return policy
+	}
+	return "wait_for"
+}
+
+// bulk sends actions as a single bulk request. Deletes of documents that do
+// not exist are not treated as failures, and neither are versioned writes
+// rejected because the index already holds a newer version; those are logged
+// as stale and skipped.
+func bulk(index string, actions []bulkAction) error {
+	if len(actions) == 0 {
+		return nil
+	}
+	if config.ESClient == nil {
+		return errors.New("elasticsearch client is not initialized")
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, action := range actions {
+		meta := map[string]interface{}{"_id": action.ID}
+		if action.Routing != "" {
+			meta["routing"] = action.Routing
+		}
+		if action.Version > 0 {
+			meta["version"] = action.Version
+			meta["version_type"] = "external"
+		}
+		if err := encoder.Encode(map[string]interface{}{action.Op: meta}); err != nil {
+			return fmt.Errorf("failed to marshal bulk action: %v", err)
+		}
+
+		switch action.Op {
+		case "index":
+			if err := encoder.Encode(action.Body); err != nil {
+				return fmt.Errorf("failed to marshal document %s: %v", action.ID, err)
+			}
+		case "update":
+			if err := encoder.Encode(map[string]interface{}{"doc": action.Body, "doc_as_upsert": true}); err != nil {
+				return fmt.Errorf("failed to marshal document %s: %v", action.ID, err)
+			}
+		}
+	}
+
+	req := esapi.BulkRequest{
+		Index:   index,
+		Body:    &body,
+		Refresh: refreshPolicy(),
+	}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return fmt.Errorf("failed to send bulk request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("bulk request failed: %s", res.String())
+	}
+
+	var bulkResponse struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&bulkResponse); err != nil {
+		return fmt.Errorf("failed to parse bulk response: %v", err)
+	}
+
+	var failures []BulkItemFailure
+	for _, item := range bulkResponse.Items {
+		for op, result := range item {
+			if result.Error == nil {
+				continue
+			}
+			if result.Status == 409 && result.Error.Type == "version_conflict_engine_exception" {
+				log.Printf("Skipped stale %s of document %s in index %s: %s", op, result.ID, index, result.Error.Reason)
+				continue
+			}
+			failures = append(failures, BulkItemFailure{
+				Op:     op,
+				ID:     result.ID,
+				Status: result.Status,
+				Type:   result.Error.Type,
+				Reason: result.Error.Reason,
+			})
+		}
+	}
+	if len(failures) > 0 {
+		return &BulkError{Failures: failures}
+	}
+
+	log.Printf("Bulk request applied %d actions to Elasticsearch index: %s", len(actions), index)
+	return nil
+}
@@ -4,11 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"log"
-	"strings"
+	"time"
 
 	"csye7255-project-one/config"
 	"csye7255-project-one/models"
@@ -16,105 +14,8 @@ import (
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 )
 
-func CreateIndexIfNotExists(indexName string) error {
-	mapping := `
-	{
-		"mappings": {
-			"properties": {
-				"relation": {
-					"type": "join",
-					"relations": {
-						"plan": ["planCostShares", "linkedPlanServices"],
-						"planCostShares": [],
-						"linkedPlanServices": ["linkedService", "planServiceCostShares"],
-						"linkedService": [],
-						"planServiceCostShares": []
-					}
-				},
-				"planCostShares": {
-					"type": "nested",
-					"properties": {
-						"_org": { "type": "text" },
-						"copay": { "type": "integer" },
-						"deductible": { "type": "integer" },
-						"objectId": { "type": "text" },
-						"objectType": { "type": "text" }
-					}
-				},
-				"linkedPlanServices": {
-					"type": "nested",
-					"properties": {
-						"_org": { "type": "text" },
-						"objectId": { "type": "text" },
-						"objectType": { "type": "text" },
-						"linkedService": {
-							"type": "nested",
-							"properties": {
-								"_org": { "type": "text" },
-								"objectId": { "type": "text" },
-								"objectType": { "type": "text" },
-								"name": { "type": "text" }
-							}
-						},
-						"planserviceCostShares": {
-							"type": "nested",
-							"properties": {
-								"_org": { "type": "text" },
-								"copay": { "type": "integer" },
-								"deductible": { "type": "integer" },
-								"objectId": { "type": "text" },
-								"objectType": { "type": "text" }
-							}
-						}
-					}
-				},
-				"_org": { "type": "text" },
-				"objectId": { "type": "text" },
-				"objectType": { "type": "text" },
-				"planType": { "type": "text" },
-				"creationDate": { "type": "text" }
-			}
-		}
-	}`
-
-	existsReq := esapi.IndicesExistsRequest{
-		Index: []string{indexName},
-	}
-
-	existsRes, err := existsReq.Do(context.Background(), config.ESClient)
-	if err != nil {
-		return fmt.Errorf("error checking if index exists: %v", err)
-	}
-	defer existsRes.Body.Close()
-
-	if existsRes.StatusCode == 200 {
-		log.Printf("Index %s already exists", indexName)
-		return nil
-	} else if existsRes.StatusCode != 404 {
-		return fmt.Errorf("unexpected status code when checking index existence: %d", existsRes.StatusCode)
-	}
-
-	createReq := esapi.IndicesCreateRequest{
-		Index: indexName,
-		Body:  strings.NewReader(mapping),
-	}
-
-	createRes, err := createReq.Do(context.Background(), config.ESClient)
-	if err != nil {
-		return fmt.Errorf("error creating index: %v", err)
-	}
-	defer createRes.Body.Close()
-
-	if createRes.IsError() {
-		return fmt.Errorf("error creating index: %s", createRes.String())
-	}
-
-	log.Printf("Index %s created successfully with the specified parent-child mapping.", indexName)
-	return nil
-}
-
-func SaveParentAndChildrenToElasticsearch(index string, plan models.Plan) error {
-	planDoc := map[string]interface{}{
+func planDocument(plan models.Plan) map[string]interface{} {
+	return map[string]interface{}{
 		"relation": map[string]interface{}{
 			"name": "plan",
 		},
@@ -124,335 +25,373 @@ func SaveParentAndChildrenToElasticsearch(index string, plan models.Plan) error
 		"planType":     plan.PlanType,
 		"creationDate": plan.CreationDate,
 	}
-	if err := saveToElasticsearch(index, plan.ObjectId, planDoc, ""); err != nil {
-		return fmt.Errorf("failed to save plan document: %v", err)
-	}
+}
 
-	planCostSharesDoc := map[string]interface{}{
+func planCostSharesDocument(planID string, costShares models.PlanCostShares) map[string]interface{} {
+	return map[string]interface{}{
 		"relation": map[string]interface{}{
 			"name":   "planCostShares",
-			"parent": plan.ObjectId,
+			"parent": planID,
 		},
-		"_org":       plan.PlanCostShares.Org,
-		"copay":      plan.PlanCostShares.Copay,
-		"deductible": plan.PlanCostShares.Deductible,
-		"objectId":   plan.PlanCostShares.ObjectId,
-		"objectType": plan.PlanCostShares.ObjectType,
+		"_org":       costShares.Org,
+		"copay":      costShares.Copay,
+		"deductible": costShares.Deductible,
+		"objectId":   costShares.ObjectId,
+		"objectType": costShares.ObjectType,
 	}
-	if err := saveToElasticsearch(index, plan.PlanCostShares.ObjectId, planCostSharesDoc, plan.ObjectId); err != nil {
-		return fmt.Errorf("failed to save PlanCostShares document: %v", err)
-	}
-
-	for _, linkedService := range plan.LinkedPlanServices {
-		linkedPlanServiceDoc := map[string]interface{}{
-			"relation": map[string]interface{}{
-				"name":   "linkedPlanServices",
-				"parent": plan.ObjectId,
-			},
-			"_org":       linkedService.Org,
-			"objectId":   linkedService.ObjectId,
-			"objectType": linkedService.ObjectType,
-		}
-		if err := saveToElasticsearch(index, linkedService.ObjectId, linkedPlanServiceDoc, plan.ObjectId); err != nil {
-			return fmt.Errorf("failed to save LinkedPlanService document: %v", err)
-		}
-
-		linkedServiceDoc := map[string]interface{}{
-			"relation": map[string]interface{}{
-				"name":   "linkedService",
-				"parent": linkedService.ObjectId,
-			},
-			"_org":       linkedService.LinkedService.Org,
-			"objectId":   linkedService.LinkedService.ObjectId,
-			"objectType": linkedService.LinkedService.ObjectType,
-			"name":       linkedService.LinkedService.Name,
-		}
-		if err := saveToElasticsearch(index, linkedService.LinkedService.ObjectId, linkedServiceDoc, linkedService.ObjectId); err != nil {
-			return fmt.Errorf("failed to save LinkedService document: %v", err)
-		}
+}
 
-		planServiceCostSharesDoc := map[string]interface{}{
-			"relation": map[string]interface{}{
-				"name":   "planServiceCostShares",
-				"parent": linkedService.ObjectId,
-			},
-			"_org":       linkedService.PlanServiceCostShares.Org,
-			"copay":      linkedService.PlanServiceCostShares.Copay,
-			"deductible": linkedService.PlanServiceCostShares.Deductible,
-			"objectId":   linkedService.PlanServiceCostShares.ObjectId,
-			"objectType": linkedService.PlanServiceCostShares.ObjectType,
-		}
-		if err := saveToElasticsearch(index, linkedService.PlanServiceCostShares.ObjectId, planServiceCostSharesDoc, linkedService.ObjectId); err != nil {
-			return fmt.Errorf("failed to save PlanServiceCostShares document: %v", err)
-		}
+func linkedPlanServiceDocument(planID string, linkedPlanService models.LinkedPlanService) map[string]interface{} {
+	return map[string]interface{}{
+		"relation": map[string]interface{}{
+			"name":   "linkedPlanServices",
+			"parent": planID,
+		},
+		"_org":       linkedPlanService.Org,
+		"objectId":   linkedPlanService.ObjectId,
+		"objectType": linkedPlanService.ObjectType,
 	}
-	return nil
 }
 
-func PatchParentAndChildren(index string, plan models.Plan) error {
-	planDoc := map[string]interface{}{
+func linkedServiceDocument(linkedPlanServiceID string, linkedService models.LinkedService) map[string]interface{} {
+	return map[string]interface{}{
 		"relation": map[string]interface{}{
-			"name": "plan",
+			"name":   "linkedService",
+			"parent": linkedPlanServiceID,
 		},
-		"_org":         plan.Org,
-		"objectId":     plan.ObjectId,
-		"objectType":   plan.ObjectType,
-		"planType":     plan.PlanType,
-		"creationDate": plan.CreationDate,
+		"_org":       linkedService.Org,
+		"objectId":   linkedService.ObjectId,
+		"objectType": linkedService.ObjectType,
+		"name":       linkedService.Name,
 	}
+}
 
-	if err := saveOrUpdateChild(index, plan.ObjectId, planDoc, ""); err != nil {
-		return fmt.Errorf("failed to update Plan document: %v", err)
+func planServiceCostSharesDocument(linkedPlanServiceID string, costShares models.PlanServiceCostShares) map[string]interface{} {
+	return map[string]interface{}{
+		"relation": map[string]interface{}{
+			"name":   "planServiceCostShares",
+			"parent": linkedPlanServiceID,
+		},
+		"_org":       costShares.Org,
+		"copay":      costShares.Copay,
+		"deductible": costShares.Deductible,
+		"objectId":   costShares.ObjectId,
+		"objectType": costShares.ObjectType,
 	}
+}
 
-	if len(plan.PlanCostShares.ObjectId) > 0 {
-		planCostSharesDoc := map[string]interface{}{
-			"relation": map[string]interface{}{
-				"name":   "planCostShares",
-				"parent": plan.ObjectId,
-			},
-			"_org":       plan.PlanCostShares.Org,
-			"copay":      plan.PlanCostShares.Copay,
-			"deductible": plan.PlanCostShares.Deductible,
-			"objectId":   plan.PlanCostShares.ObjectId,
-			"objectType": plan.PlanCostShares.ObjectType,
-		}
-		if err := saveOrUpdateChild(index, plan.PlanCostShares.ObjectId, planCostSharesDoc, plan.ObjectId); err != nil {
-			return fmt.Errorf("failed to update PlanCostShares document: %v", err)
-		}
-	}
+// indexedDocument is one document of a plan's subtree in the join index. Every
+// document of the subtree is routed by the plan id so the whole join tree
+// lives on one shard.
+type indexedDocument struct {
+	ID      string
+	Routing string
+	Body    map[string]interface{}
+}
 
+// planDocuments flattens plan into the documents it is indexed as.
+func planDocuments(plan models.Plan) []indexedDocument {
+	docs := []indexedDocument{
+		{ID: plan.ObjectId, Routing: plan.ObjectId, Body: planDocument(plan)},
+		{ID: plan.PlanCostShares.ObjectId, Routing: plan.ObjectId, Body: planCostSharesDocument(plan.ObjectId, plan.PlanCostShares)},
+	}
 	for _, linkedPlanService := range plan.LinkedPlanServices {
-		linkedPlanServiceDoc := map[string]interface{}{
-			"relation": map[string]interface{}{
-				"name":   "linkedPlanServices",
-				"parent": plan.ObjectId,
-			},
-			"_org":       linkedPlanService.Org,
-			"objectId":   linkedPlanService.ObjectId,
-			"objectType": linkedPlanService.ObjectType,
-		}
-		if err := saveOrUpdateChild(index, linkedPlanService.ObjectId, linkedPlanServiceDoc, plan.ObjectId); err != nil {
-			return fmt.Errorf("failed to update LinkedPlanService document: %v", err)
-		}
-
-		linkedServiceDoc := map[string]interface{}{
-			"relation": map[string]interface{}{
-				"name":   "linkedService",
-				"parent": linkedPlanService.ObjectId,
-			},
-			"_org":       linkedPlanService.LinkedService.Org,
-			"objectId":   linkedPlanService.LinkedService.ObjectId,
-			"objectType": linkedPlanService.LinkedService.ObjectType,
-			"name":       linkedPlanService.LinkedService.Name,
-		}
-		if err := saveOrUpdateChild(index, linkedPlanService.LinkedService.ObjectId, linkedServiceDoc, linkedPlanService.ObjectId); err != nil {
-			return fmt.Errorf("failed to update LinkedService document: %v", err)
-		}
-
-		planServiceCostSharesDoc := map[string]interface{}{
-			"relation": map[string]interface{}{
-				"name":   "planServiceCostShares",
-				"parent": linkedPlanService.ObjectId,
-			},
-			"_org":       linkedPlanService.PlanServiceCostShares.Org,
-			"copay":      linkedPlanService.PlanServiceCostShares.Copay,
-			"deductible": linkedPlanService.PlanServiceCostShares.Deductible,
-			"objectId":   linkedPlanService.PlanServiceCostShares.ObjectId,
-			"objectType": linkedPlanService.PlanServiceCostShares.ObjectType,
-		}
-		if err := saveOrUpdateChild(index, linkedPlanService.PlanServiceCostShares.ObjectId, planServiceCostSharesDoc, linkedPlanService.ObjectId); err != nil {
-			return fmt.Errorf("failed to update PlanServiceCostShares document: %v", err)
-		}
+		docs = append(docs, linkedPlanServiceDocuments(plan.ObjectId, linkedPlanService)...)
 	}
+	return docs
+}
 
-	return nil
+func linkedPlanServiceDocuments(planID string, linkedPlanService models.LinkedPlanService) []indexedDocument {
+	return []indexedDocument{
+		{ID: linkedPlanService.ObjectId, Routing: planID, Body: linkedPlanServiceDocument(planID, linkedPlanService)},
+		{ID: linkedPlanService.LinkedService.ObjectId, Routing: planID, Body: linkedServiceDocument(linkedPlanService.ObjectId, linkedPlanService.LinkedService)},
+		{ID: linkedPlanService.PlanServiceCostShares.ObjectId, Routing: planID, Body: planServiceCostSharesDocument(linkedPlanService.ObjectId, linkedPlanService.PlanServiceCostShares)},
+	}
 }
 
-func DeleteParentAndChildren(index string, parentID string) error {
-	if err := deleteDescendants(index, parentID, "planCostShares"); err != nil {
-		return fmt.Errorf("failed to delete descendants of PlanCostShares: %v", err)
+func indexActions(docs []indexedDocument, version int64) []bulkAction {
+	actions := make([]bulkAction, 0, len(docs))
+	for _, doc := range docs {
+		actions = append(actions, bulkAction{Op: "index", ID: doc.ID, Routing: doc.Routing, Version: version, Body: doc.Body})
 	}
-	if err := deleteDescendants(index, parentID, "linkedPlanServices"); err != nil {
-		return fmt.Errorf("failed to delete descendants of LinkedPlanServices: %v", err)
+	return actions
+}
+
+func deleteActions(hits []esHit, version int64) []bulkAction {
+	actions := make([]bulkAction, 0, len(hits))
+	for _, hit := range hits {
+		actions = append(actions, bulkAction{Op: "delete", ID: hit.ID, Routing: hit.Routing, Version: version})
 	}
+	return actions
+}
 
-	if err := deleteFromElasticsearch(index, parentID); err != nil {
-		return fmt.Errorf("failed to delete Plan document: %v", err)
+// SaveParentAndChildrenToElasticsearch indexes plan and deletes, in the same
+// bulk request, any previously indexed descendants it no longer contains.
+// Writes carry version as an external version so an older change never
+// overwrites a newer one; version 0 writes unversioned. Documents indexed
+// before versioning was introduced have internal versions that new external
+// versions may not exceed, so such indices should be rebuilt.
+func SaveParentAndChildrenToElasticsearch(index string, plan models.Plan, version int64) error {
+	indexed, err := fetchPlanDocuments(index, plan.ObjectId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch indexed documents of %s: %v", plan.ObjectId, err)
 	}
 
-	log.Printf("Successfully deleted Plan document %s and all its descendants", parentID)
+	actions := planSyncActions(indexed, planDocuments(plan), plan.ObjectId, version)
+	if err := bulk(index, actions); err != nil {
+		return fmt.Errorf("failed to save plan %s: %v", plan.ObjectId, err)
+	}
 	return nil
 }
 
-func deleteDescendants(index string, parentID string, childType string) error {
-	searchQuery := map[string]interface{}{
-		"query": map[string]interface{}{
-			"parent_id": map[string]interface{}{
-				"type": childType,
-				"id":   parentID,
-			},
-		},
-	}
-	searchBody, err := json.Marshal(searchQuery)
+// PatchParentAndChildren re-indexes the documents of a patched plan and
+// deletes descendants it no longer contains. Whole documents are written
+// rather than partial updates since updates cannot be externally versioned.
+func PatchParentAndChildren(index string, plan models.Plan, version int64) error {
+	indexed, err := fetchPlanDocuments(index, plan.ObjectId)
 	if err != nil {
-		return fmt.Errorf("failed to create search query for %s: %v", childType, err)
+		return fmt.Errorf("failed to fetch indexed documents of %s: %v", plan.ObjectId, err)
 	}
 
-	searchReq := esapi.SearchRequest{
-		Index: []string{index},
-		Body:  bytes.NewReader(searchBody),
+	actions := planSyncActions(indexed, patchDocuments(plan, indexed), plan.ObjectId, version)
+	if err := bulk(index, actions); err != nil {
+		return fmt.Errorf("failed to patch plan %s: %v", plan.ObjectId, err)
 	}
-	searchRes, err := searchReq.Do(context.Background(), config.ESClient)
-	if err != nil {
-		return fmt.Errorf("failed to search for %s children: %v", childType, err)
+	return nil
+}
+
+// patchDocuments returns the documents of a patched plan. A patch without
+// planCostShares carries the indexed one over as it is.
+func patchDocuments(plan models.Plan, indexed map[string]esHit) []indexedDocument {
+	docs := planDocuments(plan)
+	if len(plan.PlanCostShares.ObjectId) > 0 {
+		return docs
 	}
-	defer searchRes.Body.Close()
 
-	if searchRes.IsError() {
-		return fmt.Errorf("failed to search for %s children: %s", childType, searchRes.Status())
+	docs = append(docs[:1], docs[2:]...)
+	for _, hit := range indexed {
+		if relationName(hit.Source) == "planCostShares" {
+			docs = append(docs, indexedDocument{ID: hit.ID, Routing: plan.ObjectId, Body: hit.Source})
+		}
 	}
+	return docs
+}
 
-	var searchResults struct {
-		Hits struct {
-			Hits []struct {
-				ID string `json:"_id"`
-			} `json:"hits"`
-		} `json:"hits"`
+// planSyncActions returns deletes for the indexed documents of the plan that
+// are not among docs, followed by index actions for docs.
+func planSyncActions(indexed map[string]esHit, docs []indexedDocument, planID string, version int64) []bulkAction {
+	hits := make([]esHit, 0, len(indexed))
+	for _, hit := range indexed {
+		hits = append(hits, hit)
 	}
-	if err := json.NewDecoder(searchRes.Body).Decode(&searchResults); err != nil {
-		return fmt.Errorf("failed to parse search results for %s: %v", childType, err)
+
+	removed := unreferencedHits(hits, docs)
+	if len(removed) > 0 {
+		log.Printf("Removing %d documents no longer referenced by plan %s", len(removed), planID)
 	}
+	return append(deleteActions(removed, version), indexActions(docs, version)...)
+}
 
-	for _, hit := range searchResults.Hits.Hits {
-		childID := hit.ID
+// unreferencedHits returns the hits that are not among docs. Documents that are
+// re-indexed must not be deleted first: the index at the same external version
+// would then be rejected as a version conflict and the document lost.
+func unreferencedHits(hits []esHit, docs []indexedDocument) []esHit {
+	kept := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		kept[doc.ID] = true
+	}
 
-		if childType == "linkedPlanServices" {
-			if err := deleteDescendants(index, childID, "linkedService"); err != nil {
-				return fmt.Errorf("failed to delete linkedService of %s: %v", childID, err)
-			}
-			if err := deleteDescendants(index, childID, "planServiceCostShares"); err != nil {
-				return fmt.Errorf("failed to delete planServiceCostShares of %s: %v", childID, err)
-			}
+	var removed []esHit
+	for _, hit := range hits {
+		if !kept[hit.ID] {
+			removed = append(removed, hit)
 		}
+	}
+	return removed
+}
 
-		if err := deleteFromElasticsearch(index, childID); err != nil {
-			return fmt.Errorf("failed to delete child document %s: %v", childID, err)
-		}
+// SavePlanCostShares replaces the planCostShares child of a plan.
+func SavePlanCostShares(index, planID string, costShares models.PlanCostShares, version int64) error {
+	previous, err := findChildren(index, planID, "planCostShares")
+	if err != nil {
+		return fmt.Errorf("failed to find previous PlanCostShares: %v", err)
 	}
 
+	docs := []indexedDocument{{ID: costShares.ObjectId, Routing: planID, Body: planCostSharesDocument(planID, costShares)}}
+	actions := append(deleteActions(unreferencedHits(previous, docs), version), indexActions(docs, version)...)
+	if err := bulk(index, actions); err != nil {
+		return fmt.Errorf("failed to save PlanCostShares of %s: %v", planID, err)
+	}
 	return nil
 }
 
-func saveToElasticsearch(index, docID string, data interface{}, parentID string) error {
-	if config.ESClient == nil {
-		return errors.New("elasticsearch client is not initialized")
+// SaveLinkedPlanService rewrites one linkedPlanServices subtree of a plan,
+// dropping grandchildren that are no longer referenced.
+func SaveLinkedPlanService(index, planID string, linkedPlanService models.LinkedPlanService, version int64) error {
+	previous, err := findLinkedPlanServiceChildren(index, linkedPlanService.ObjectId)
+	if err != nil {
+		return fmt.Errorf("failed to find previous children of %s: %v", linkedPlanService.ObjectId, err)
 	}
 
-	jsonData, err := json.Marshal(data)
+	docs := linkedPlanServiceDocuments(planID, linkedPlanService)
+	actions := append(deleteActions(unreferencedHits(previous, docs), version), indexActions(docs, version)...)
+	if err := bulk(index, actions); err != nil {
+		return fmt.Errorf("failed to save LinkedPlanService %s: %v", linkedPlanService.ObjectId, err)
+	}
+	return nil
+}
+
+// DeleteLinkedPlanService removes one linkedPlanServices subtree of a plan.
+func DeleteLinkedPlanService(index, planID, serviceID string, version int64) error {
+	children, err := findLinkedPlanServiceChildren(index, serviceID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal data: %v", err)
+		return fmt.Errorf("failed to find children of %s: %v", serviceID, err)
 	}
 
-	req := esapi.IndexRequest{
-		Index:      index,
-		DocumentID: docID,
-		Body:       bytes.NewReader(jsonData),
-		Routing:    parentID,
-		Refresh:    "true",
+	actions := deleteActions(children, version)
+	actions = append(actions, bulkAction{Op: "delete", ID: serviceID, Routing: planID, Version: version})
+	if err := bulk(index, actions); err != nil {
+		return fmt.Errorf("failed to delete LinkedPlanService %s: %v", serviceID, err)
 	}
+	return nil
+}
 
-	res, err := req.Do(context.Background(), config.ESClient)
+func DeleteParentAndChildren(index string, parentID string, version int64) error {
+	indexed, err := fetchPlanDocuments(index, parentID)
 	if err != nil {
-		return fmt.Errorf("failed to index document: %v", err)
+		return fmt.Errorf("failed to find descendants of %s: %v", parentID, err)
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		body, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("failed to save document to Elasticsearch: %s, response: %s", res.Status(), string(body))
+	hits := make([]esHit, 0, len(indexed)+1)
+	for id, hit := range indexed {
+		if id != parentID {
+			hits = append(hits, hit)
+		}
 	}
+	actions := deleteActions(hits, version)
+	actions = append(actions, bulkAction{Op: "delete", ID: parentID, Routing: parentID, Version: version})
 
-	log.Printf("Document saved to Elasticsearch index: %s, ID: %s", index, docID)
+	if err := bulk(index, actions); err != nil {
+		return fmt.Errorf("failed to delete Plan %s and its descendants: %v", parentID, err)
+	}
+
+	log.Printf("Successfully deleted Plan document %s and all its descendants", parentID)
 	return nil
 }
 
-func updateInElasticsearch(index, docID string, data interface{}, parentID string) error {
-	if config.ESClient == nil {
-		return errors.New("elasticsearch client is not initialized")
-	}
+func findChildren(index, parentID, childType string) ([]esHit, error) {
+	return searchDocuments(index, parentIDQuery(childType, parentID), 1000)
+}
+
+func findLinkedPlanServiceChildren(index, serviceID string) ([]esHit, error) {
+	return searchDocuments(index, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				parentIDQuery("linkedService", serviceID),
+				parentIDQuery("planServiceCostShares", serviceID),
+			},
+		},
+	}, 1000)
+}
+
+type esHit struct {
+	Index   string                 `json:"_index"`
+	ID      string                 `json:"_id"`
+	Routing string                 `json:"_routing"`
+	Source  map[string]interface{} `json:"_source"`
+}
+
+type esSearchResponse struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []esHit `json:"hits"`
+	} `json:"hits"`
+}
 
-	jsonData, err := json.Marshal(map[string]interface{}{"doc": data})
+// searchDocuments returns up to size documents matching query.
+func searchDocuments(index string, query map[string]interface{}, size int) ([]esHit, error) {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "size": size})
 	if err != nil {
-		return fmt.Errorf("failed to marshal data: %v", err)
+		return nil, fmt.Errorf("failed to marshal search query: %v", err)
 	}
 
-	req := esapi.UpdateRequest{
-		Index:      index,
-		DocumentID: docID,
-		Body:       bytes.NewReader(jsonData),
-		Routing:    parentID,
-		Refresh:    "true",
+	req := esapi.SearchRequest{
+		Index: []string{index},
+		Body:  bytes.NewReader(body),
 	}
-
 	res, err := req.Do(context.Background(), config.ESClient)
 	if err != nil {
-		return fmt.Errorf("failed to update document: %v", err)
+		return nil, fmt.Errorf("failed to search documents: %v", err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		return fmt.Errorf("failed to update document in Elasticsearch: %s", res.Status())
+		return nil, fmt.Errorf("failed to search documents: %s", res.Status())
 	}
 
-	log.Printf("Document updated in Elasticsearch index: %s, ID: %s", index, docID)
-	return nil
+	var searchResults esSearchResponse
+	if err := json.NewDecoder(res.Body).Decode(&searchResults); err != nil {
+		return nil, fmt.Errorf("failed to parse search results: %v", err)
+	}
+	return searchResults.Hits.Hits, nil
 }
 
-func saveOrUpdateChild(index, docID string, data interface{}, parentID string) error {
-	exists, err := documentExists(index, docID)
+// scrollDocuments calls fn with every document matching query, batch by batch.
+func scrollDocuments(index string, query map[string]interface{}, batch int, fn func([]esHit) error) error {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "size": batch})
 	if err != nil {
-		return fmt.Errorf("failed to check document existence: %v", err)
+		return fmt.Errorf("failed to marshal search query: %v", err)
 	}
 
-	if exists {
-		return updateInElasticsearch(index, docID, data, parentID)
+	req := esapi.SearchRequest{
+		Index:  []string{index},
+		Body:   bytes.NewReader(body),
+		Scroll: time.Minute,
 	}
-
-	return saveToElasticsearch(index, docID, data, parentID)
-}
-
-func documentExists(index, docID string) (bool, error) {
-	req := esapi.GetRequest{
-		Index:      index,
-		DocumentID: docID,
-	}
-
 	res, err := req.Do(context.Background(), config.ESClient)
 	if err != nil {
-		return false, fmt.Errorf("error checking document existence: %v", err)
+		return fmt.Errorf("failed to search documents: %v", err)
 	}
-	defer res.Body.Close()
 
-	return res.StatusCode == 200, nil
-}
+	for {
+		if res.IsError() {
+			res.Body.Close()
+			return fmt.Errorf("failed to scroll documents: %s", res.Status())
+		}
+
+		var page esSearchResponse
+		err := json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse search results: %v", err)
+		}
 
-func deleteFromElasticsearch(index, docID string) error {
-	req := esapi.DeleteRequest{
-		Index:      index,
-		DocumentID: docID,
+		if len(page.Hits.Hits) == 0 {
+			clearScroll(page.ScrollID)
+			return nil
+		}
+		if err := fn(page.Hits.Hits); err != nil {
+			clearScroll(page.ScrollID)
+			return err
+		}
+
+		scrollReq := esapi.ScrollRequest{ScrollID: page.ScrollID, Scroll: time.Minute}
+		res, err = scrollReq.Do(context.Background(), config.ESClient)
+		if err != nil {
+			return fmt.Errorf("failed to scroll documents: %v", err)
+		}
 	}
+}
 
+func clearScroll(scrollID string) {
+	if scrollID == "" {
+		return
+	}
+	req := esapi.ClearScrollRequest{ScrollID: []string{scrollID}}
 	res, err := req.Do(context.Background(), config.ESClient)
 	if err != nil {
-		return fmt.Errorf("error deleting document: %v", err)
-	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		return fmt.Errorf("failed to delete document from Elasticsearch: %s", res.Status())
+		log.Printf("Failed to clear scroll: %v", err)
+		return
 	}
-
-	log.Printf("Document deleted from Elasticsearch index: %s, ID: %s", index, docID)
-	return nil
+	res.Body.Close()
 }
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"csye7255-project-one/models"
+)
+
+func testPlan(costSharesID string, serviceIDs ...string) models.Plan {
+	plan := models.Plan{ObjectId: "plan", Org: "example.com", PlanCostShares: models.PlanCostShares{ObjectId: costSharesID}}
+	for _, id := range serviceIDs {
+		plan.LinkedPlanServices = append(plan.LinkedPlanServices, models.LinkedPlanService{
+			ObjectId:              id,
+			LinkedService:         models.LinkedService{ObjectId: id + "-service"},
+			PlanServiceCostShares: models.PlanServiceCostShares{ObjectId: id + "-costs"},
+		})
+	}
+	return plan
+}
+
+// indexedPlan returns the hits of plan as fetchPlanDocuments finds them.
+func indexedPlan(plan models.Plan) map[string]esHit {
+	indexed := map[string]esHit{}
+	for _, doc := range planDocuments(plan) {
+		indexed[doc.ID] = esHit{ID: doc.ID, Routing: doc.Routing, Source: doc.Body}
+	}
+	return indexed
+}
+
+func TestPatchSyncActions(t *testing.T) {
+	tests := []struct {
+		name        string
+		indexed     models.Plan
+		patched     models.Plan
+		wantDeletes []string
+		wantIndexes []string
+	}{
+		{
+			name:        "unchanged plan deletes nothing",
+			indexed:     testPlan("costs", "a"),
+			patched:     testPlan("costs", "a"),
+			wantDeletes: []string{},
+			wantIndexes: []string{"a", "a-costs", "a-service", "costs", "plan"},
+		},
+		{
+			name:        "removed service subtree",
+			indexed:     testPlan("costs", "a", "b"),
+			patched:     testPlan("costs", "a"),
+			wantDeletes: []string{"b", "b-costs", "b-service"},
+			wantIndexes: []string{"a", "a-costs", "a-service", "costs", "plan"},
+		},
+		{
+			name:        "replaced planCostShares",
+			indexed:     testPlan("old-costs", "a"),
+			patched:     testPlan("new-costs", "a"),
+			wantDeletes: []string{"old-costs"},
+			wantIndexes: []string{"a", "a-costs", "a-service", "new-costs", "plan"},
+		},
+		{
+			name:        "patch without planCostShares keeps the indexed one",
+			indexed:     testPlan("costs", "a"),
+			patched:     testPlan("", "a", "b"),
+			wantDeletes: []string{},
+			wantIndexes: []string{"a", "a-costs", "a-service", "b", "b-costs", "b-service", "costs", "plan"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexed := indexedPlan(tt.indexed)
+			actions := planSyncActions(indexed, patchDocuments(tt.patched, indexed), "plan", 3)
+
+			deletes, indexes := []string{}, []string{}
+			for _, action := range actions {
+				if action.Version != 3 || action.Routing != "plan" {
+					t.Errorf("%s %s: version %d and routing %q, want 3 and plan", action.Op, action.ID, action.Version, action.Routing)
+				}
+				if action.Op == "delete" {
+					deletes = append(deletes, action.ID)
+				} else {
+					indexes = append(indexes, action.ID)
+				}
+			}
+			sort.Strings(deletes)
+			sort.Strings(indexes)
+			if !reflect.DeepEqual(deletes, tt.wantDeletes) {
+				t.Errorf("deletes = %v, want %v", deletes, tt.wantDeletes)
+			}
+			if !reflect.DeepEqual(indexes, tt.wantIndexes) {
+				t.Errorf("indexes = %v, want %v", indexes, tt.wantIndexes)
+			}
+		})
+	}
+}
+
+func TestPatchDocumentsCarriesPlanCostSharesOver(t *testing.T) {
+	indexed := indexedPlan(testPlan("costs"))
+	for _, doc := range patchDocuments(testPlan(""), indexed) {
+		if doc.ID == "costs" {
+			if !reflect.DeepEqual(doc.Body, indexed["costs"].Source) {
+				t.Errorf("carried over %v, want the indexed source %v", doc.Body, indexed["costs"].Source)
+			}
+			return
+		}
+	}
+	t.Error("indexed planCostShares was not carried over")
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// PlanVersion is one committed version of a record. A delete is kept as a
+// version without a record, so point-in-time reads after it find nothing.
+// Versions committed before history was kept are not available.
+type PlanVersion struct {
+	Version     int64                  `json:"version"`
+	Org         string                 `json:"_org,omitempty"`
+	CommittedAt time.Time              `json:"committedAt"`
+	Deleted     bool                   `json:"deleted,omitempty"`
+	ETag        string                 `json:"etag,omitempty"`
+	Record      map[string]interface{} `json:"record,omitempty"`
+}
+
+// VersionPage is one page of a record's history, oldest version first, without
+// the records themselves. NextCursor is empty on the last page.
+type VersionPage struct {
+	Versions   []PlanVersion
+	NextCursor string
+}
+
+// newPlanVersion describes the record after a write, nil for a delete. before
+// supplies the _org of a deleted record.
+func newPlanVersion(version int64, committedAt time.Time, before, after []byte) (PlanVersion, error) {
+	planVersion := PlanVersion{Version: version, CommittedAt: committedAt, Deleted: after == nil}
+	if after == nil {
+		planVersion.Org = recordOrg(before)
+		return planVersion, nil
+	}
+
+	doc, etag, err := decodeForAudit(after)
+	if err != nil {
+		return planVersion, err
+	}
+	planVersion.Org = recordOrg(after)
+	planVersion.ETag = etag
+	planVersion.Record, _ = doc.(map[string]interface{})
+	return planVersion, nil
+}
+
+// versionSummary drops the record of a version for listings.
+func versionSummary(data []byte) (PlanVersion, error) {
+	var version PlanVersion
+	if err := json.Unmarshal(data, &version); err != nil {
+		return version, err
+	}
+	version.Record = nil
+	return version, nil
+}
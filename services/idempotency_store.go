@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultIdempotencyTTL = 24 * time.Hour
+
+	// IdempotencyLockTTL bounds how long a claimed key stays in progress, so a
+	// request that dies without releasing its key only blocks retries briefly.
+	IdempotencyLockTTL = time.Minute
+)
+
+// IdempotentRequest is what is kept for an Idempotency-Key: the fingerprint of
+// the request that first used it and, once that request finished, its
+// response.
+type IdempotentRequest struct {
+	Fingerprint string            `json:"fingerprint"`
+	Completed   bool              `json:"completed"`
+	Status      int               `json:"status,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps idempotent requests for a TTL. BeginRequest claims
+// key for a new request until lockTTL passes; when key is already claimed it
+// returns the request stored under it and false instead. CompleteRequest
+// keeps the response for the full ttl.
+type IdempotencyStore interface {
+	BeginRequest(key, fingerprint string, lockTTL time.Duration) (*IdempotentRequest, bool, error)
+	CompleteRequest(key string, request IdempotentRequest, ttl time.Duration) error
+	// ReleaseRequest forgets key so the request can be retried, unless key is
+	// no longer claimed by the in-progress request with fingerprint.
+	ReleaseRequest(key, fingerprint string) error
+}
+
+// IdempotencyTTL is how long Idempotency-Keys are remembered, set with
+// IDEMPOTENCY_TTL, e.g. "1h". It defaults to 24 hours.
+func IdempotencyTTL() time.Duration {
+	if raw := os.Getenv("IDEMPOTENCY_TTL"); raw != "" {
+		if ttl, err := time.ParseDuration(raw); err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Invalid IDEMPOTENCY_TTL %q, using %s", raw, defaultIdempotencyTTL)
+	}
+	return defaultIdempotencyTTL
+}
+
+// RedisIdempotencyStore keeps every request as a JSON string key that expires
+// after the TTL.
+type RedisIdempotencyStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisIdempotencyStore(client *redis.Client, prefix string) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{client: client, prefix: prefix}
+}
+
+func (s *RedisIdempotencyStore) requestKey(key string) string {
+	return fmt.Sprintf("%s:%s", s.prefix, key)
+}
+
+func (s *RedisIdempotencyStore) BeginRequest(key, fingerprint string, lockTTL time.Duration) (*IdempotentRequest, bool, error) {
+	ctx := context.Background()
+	data, err := json.Marshal(IdempotentRequest{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The key may expire between SETNX and GET, so try again once
+	for attempt := 0; attempt < 2; attempt++ {
+		claimed, err := s.client.SetNX(ctx, s.requestKey(key), data, lockTTL).Result()
+		if err != nil || claimed {
+			return nil, claimed, err
+		}
+
+		existing, err := s.client.Get(ctx, s.requestKey(key)).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		var request IdempotentRequest
+		if err := json.Unmarshal([]byte(existing), &request); err != nil {
+			return nil, false, err
+		}
+		return &request, false, nil
+	}
+	return nil, false, fmt.Errorf("failed to claim idempotency key %s", key)
+}
+
+func (s *RedisIdempotencyStore) CompleteRequest(key string, request IdempotentRequest, ttl time.Duration) error {
+	request.Completed = true
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(context.Background(), s.requestKey(key), data, ttl).Err()
+}
+
+// releaseScript deletes KEYS[1] only while it still holds ARGV[1], so a claim
+// that expired and was taken by another request is left alone.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (s *RedisIdempotencyStore) ReleaseRequest(key, fingerprint string) error {
+	data, err := json.Marshal(IdempotentRequest{Fingerprint: fingerprint})
+	if err != nil {
+		return err
+	}
+	return releaseScript.Run(context.Background(), s.client, []string{s.requestKey(key)}, data).Err()
+}
+
+// MemoryIdempotencyStore is an in-process IdempotencyStore for tests and local
+// development.
+type MemoryIdempotencyStore struct {
+	mu       sync.Mutex
+	requests map[string]memoryIdempotentRequest
+}
+
+type memoryIdempotentRequest struct {
+	request   IdempotentRequest
+	expiresAt time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{requests: make(map[string]memoryIdempotentRequest)}
+}
+
+func (s *MemoryIdempotencyStore) BeginRequest(key, fingerprint string, lockTTL time.Duration) (*IdempotentRequest, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.requests[key]; ok && time.Now().Before(existing.expiresAt) {
+		request := existing.request
+		return &request, false, nil
+	}
+	s.requests[key] = memoryIdempotentRequest{
+		request:   IdempotentRequest{Fingerprint: fingerprint},
+		expiresAt: time.Now().Add(lockTTL),
+	}
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) CompleteRequest(key string, request IdempotentRequest, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request.Completed = true
+	s.requests[key] = memoryIdempotentRequest{request: request, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) ReleaseRequest(key, fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.requests[key]
+	if ok && !existing.request.Completed && existing.request.Fingerprint == fingerprint {
+		delete(s.requests, key)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryIdempotencyStoreRelease(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(store *MemoryIdempotencyStore)
+		fingerprint string
+		wantRelease bool
+	}{
+		{
+			name:        "own claim",
+			setup:       func(store *MemoryIdempotencyStore) { store.BeginRequest("key", "a", time.Minute) },
+			fingerprint: "a",
+			wantRelease: true,
+		},
+		{
+			name:        "claim of another request",
+			setup:       func(store *MemoryIdempotencyStore) { store.BeginRequest("key", "b", time.Minute) },
+			fingerprint: "a",
+		},
+		{
+			name: "completed request",
+			setup: func(store *MemoryIdempotencyStore) {
+				store.BeginRequest("key", "a", time.Minute)
+				store.CompleteRequest("key", IdempotentRequest{Fingerprint: "a", Status: 201}, time.Hour)
+			},
+			fingerprint: "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryIdempotencyStore()
+			tt.setup(store)
+			if err := store.ReleaseRequest("key", tt.fingerprint); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+
+			_, claimed, err := store.BeginRequest("key", tt.fingerprint, time.Minute)
+			if err != nil {
+				t.Fatalf("begin: %v", err)
+			}
+			if claimed != tt.wantRelease {
+				t.Errorf("released = %v, want %v", claimed, tt.wantRelease)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"csye7255-project-one/config"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// planIndexMappingVersion is recorded in the _meta of every physical index.
+// Bump it whenever planIndexMappings changes; indices with an older version
+// are migrated by the reindex command.
+const planIndexMappingVersion = 2
+
+// planIndexMappings is the mapping of the physical plan indices. Every
+// relation is a separate document, so all fields live at the top level.
+// Identifiers are text with a keyword subfield for exact matches and
+// aggregations, and creationDate is parsed in the MM-dd-yyyy format enforced
+// by validation.
+var planIndexMappings = fmt.Sprintf(`{
+	"_meta": { "mappingVersion": %d },
+	"properties": {
+		"relation": {
+			"type": "join",
+			"relations": {
+				"plan": ["planCostShares", "linkedPlanServices"],
+				"planCostShares": [],
+				"linkedPlanServices": ["linkedService", "planServiceCostShares"],
+				"linkedService": [],
+				"planServiceCostShares": []
+			}
+		},
+		"_org": { "type": "text", "fields": { "keyword": { "type": "keyword" } } },
+		"objectId": { "type": "text", "fields": { "keyword": { "type": "keyword" } } },
+		"objectType": { "type": "text", "fields": { "keyword": { "type": "keyword" } } },
+		"planType": { "type": "text", "fields": { "keyword": { "type": "keyword" } } },
+		"creationDate": { "type": "date", "format": "MM-dd-yyyy" },
+		"name": { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } },
+		"copay": { "type": "integer" },
+		"deductible": { "type": "integer" }
+	}
+}`, planIndexMappingVersion)
+
+// Consumers cache the reindex target of an alias for this long, so a reindex
+// waits this long after announcing its target before loading from Redis.
+const reindexTargetTTL = 5 * time.Second
+
+var (
+	reindexTargets   = make(map[string]cachedReindexTarget)
+	reindexTargetsMu sync.Mutex
+)
+
+type cachedReindexTarget struct {
+	index     string
+	fetchedAt time.Time
+}
+
+// physicalIndexName names the physical index of alias at a given version,
+// e.g. plans_v2.
+func physicalIndexName(alias string, version int) string {
+	return fmt.Sprintf("%s_v%d", alias, version)
+}
+
+// reindexAlias marks the physical index a reindex of alias is building.
+// Consumers write every change to it as well while it exists.
+func reindexAlias(alias string) string {
+	return alias + "_reindex"
+}
+
+// CreateIndexIfNotExists makes sure alias resolves to an index. A fresh
+// cluster gets physical index <alias>_v1 behind alias as its write index. A
+// concrete index named alias, created before aliases were introduced, keeps
+// working until the reindex command migrates it.
+func CreateIndexIfNotExists(alias string) error {
+	indices, err := resolveAlias(alias)
+	if err != nil {
+		return err
+	}
+	if len(indices) > 0 {
+		log.Printf("Alias %s already points to %s", alias, strings.Join(indices, ", "))
+		warnIfMappingOutdated(alias)
+		return nil
+	}
+
+	exists, err := indexExists(alias)
+	if err != nil {
+		return err
+	}
+	if exists {
+		log.Printf("Index %s is a concrete index with an outdated mapping; run the reindex command to move it behind an alias", alias)
+		return nil
+	}
+
+	index := physicalIndexName(alias, 1)
+	if err := createIndex(index, map[string]interface{}{alias: map[string]interface{}{"is_write_index": true}}); err != nil {
+		return err
+	}
+	log.Printf("Index %s created successfully with the specified parent-child mapping behind alias %s.", index, alias)
+	return nil
+}
+
+// resolveAlias returns the physical indices alias points to, or none when
+// the alias does not exist.
+func resolveAlias(alias string) ([]string, error) {
+	req := esapi.IndicesGetAliasRequest{Name: []string{alias}}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving alias %s: %v", alias, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return nil, nil
+	} else if res.IsError() {
+		return nil, fmt.Errorf("error resolving alias %s: %s", alias, res.Status())
+	}
+
+	var aliases map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&aliases); err != nil {
+		return nil, fmt.Errorf("failed to parse aliases of %s: %v", alias, err)
+	}
+
+	indices := make([]string, 0, len(aliases))
+	for index := range aliases {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+func indexExists(index string) (bool, error) {
+	req := esapi.IndicesExistsRequest{Index: []string{index}}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return false, fmt.Errorf("error checking if index exists: %v", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status code when checking index existence: %d", res.StatusCode)
+	}
+}
+
+func createIndex(index string, aliases map[string]interface{}) error {
+	body := map[string]interface{}{"mappings": json.RawMessage(planIndexMappings)}
+	if len(aliases) > 0 {
+		body["aliases"] = aliases
+	}
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index settings: %v", err)
+	}
+
+	req := esapi.IndicesCreateRequest{
+		Index: index,
+		Body:  bytes.NewReader(bodyJSON),
+	}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return fmt.Errorf("error creating index: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error creating index: %s", res.String())
+	}
+	return nil
+}
+
+// updateAliases applies alias actions in one atomic request.
+func updateAliases(actions []map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alias actions: %v", err)
+	}
+
+	req := esapi.IndicesUpdateAliasesRequest{Body: bytes.NewReader(body)}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return fmt.Errorf("error updating aliases: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error updating aliases: %s", res.String())
+	}
+	return nil
+}
+
+func deleteIndex(index string) error {
+	req := esapi.IndicesDeleteRequest{Index: []string{index}}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return fmt.Errorf("error deleting index: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error deleting index %s: %s", index, res.Status())
+	}
+	return nil
+}
+
+// reindexTarget returns the index a running reindex of alias is building, or
+// "" when none is running.
+func reindexTarget(alias string) (string, error) {
+	reindexTargetsMu.Lock()
+	cached, ok := reindexTargets[alias]
+	reindexTargetsMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < reindexTargetTTL {
+		return cached.index, nil
+	}
+
+	indices, err := resolveAlias(reindexAlias(alias))
+	if err != nil {
+		return "", err
+	}
+	target := ""
+	if len(indices) > 0 {
+		target = indices[0]
+	}
+
+	reindexTargetsMu.Lock()
+	reindexTargets[alias] = cachedReindexTarget{index: target, fetchedAt: time.Now()}
+	reindexTargetsMu.Unlock()
+	return target, nil
+}
+
+// nextIndexVersion returns the version after the newest physical index of
+// alias in indices.
+func nextIndexVersion(alias string, indices []string) int {
+	next := 1
+	for _, index := range indices {
+		suffix, ok := strings.CutPrefix(index, alias+"_v")
+		if !ok {
+			continue
+		}
+		if version, err := strconv.Atoi(suffix); err == nil && version >= next {
+			next = version + 1
+		}
+	}
+	return next
+}
+
+// listPhysicalIndices returns the existing <alias>_vN indices.
+func listPhysicalIndices(alias string) ([]string, error) {
+	req := esapi.IndicesGetRequest{Index: []string{alias + "_v*"}}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return nil, fmt.Errorf("error listing indices of %s: %v", alias, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error listing indices of %s: %s", alias, res.Status())
+	}
+
+	var indices map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return nil, fmt.Errorf("failed to parse indices of %s: %v", alias, err)
+	}
+
+	names := make([]string, 0, len(indices))
+	for name := range indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// MappingOutdated reports whether any index behind alias, or a concrete index
+// named alias, was created with an older mapping than planIndexMappings.
+func MappingOutdated(alias string) (bool, error) {
+	req := esapi.IndicesGetMappingRequest{Index: []string{alias}}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return false, fmt.Errorf("error fetching mapping of %s: %v", alias, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return false, nil
+	} else if res.IsError() {
+		return false, fmt.Errorf("error fetching mapping of %s: %s", alias, res.Status())
+	}
+
+	var mappings map[string]struct {
+		Mappings struct {
+			Meta struct {
+				MappingVersion int `json:"mappingVersion"`
+			} `json:"_meta"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&mappings); err != nil {
+		return false, fmt.Errorf("failed to parse mapping of %s: %v", alias, err)
+	}
+
+	for _, mapping := range mappings {
+		if mapping.Mappings.Meta.MappingVersion < planIndexMappingVersion {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func warnIfMappingOutdated(alias string) {
+	outdated, err := MappingOutdated(alias)
+	if err != nil {
+		log.Printf("Failed to check the mapping of %s: %v", alias, err)
+	} else if outdated {
+		log.Printf("Index behind %s has an outdated mapping; run the reindex command to migrate it", alias)
+	}
+}
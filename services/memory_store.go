@@ -0,0 +1,408 @@
+package services
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryRecordStore is an in-process RecordStore for tests and local
+// development. Records are kept as JSON so reads behave like the Redis store.
+type MemoryRecordStore struct {
+	mu          sync.RWMutex
+	records     map[string][]byte
+	versions    map[string]int64
+	outbox      []OutboxEvent
+	nextEventID int64
+	eventAdded  chan struct{}
+	checkpoints map[string]string
+	audit       map[string][]AuditEntry
+	history     map[string][]PlanVersion
+	tombstones  map[string]Tombstone
+}
+
+func NewMemoryRecordStore() *MemoryRecordStore {
+	return &MemoryRecordStore{
+		records:     make(map[string][]byte),
+		versions:    make(map[string]int64),
+		eventAdded:  make(chan struct{}, 1),
+		checkpoints: make(map[string]string),
+		audit:       make(map[string][]AuditEntry),
+		history:     make(map[string][]PlanVersion),
+		tombstones:  make(map[string]Tombstone),
+	}
+}
+
+func (s *MemoryRecordStore) Exists(id string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, exists := s.records[id]
+	return exists, nil
+}
+
+func (s *MemoryRecordStore) Save(id string, data interface{}, opts WriteOptions) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tombstone, deleted := s.tombstones[id]; deleted && !tombstone.Expired() {
+		if _, exists := s.records[id]; !exists {
+			return ErrRecordDeleted
+		}
+	}
+	return s.write(id, jsonData, opts)
+}
+
+// write bumps the version of id and stores record, or moves the current record
+// to a tombstone when record is nil, together with its events, audit entry and
+// history entry. It must be called with s.mu held.
+func (s *MemoryRecordStore) write(id string, record []byte, opts WriteOptions) error {
+	if opts.IfVersion != nil && s.versions[id] != *opts.IfVersion {
+		return ErrVersionConflict
+	}
+	version := s.versions[id] + 1
+	stamped, err := stampVersion(opts.Events, version)
+	if err != nil {
+		return err
+	}
+	committedAt := time.Now().UTC()
+	planVersion, err := newPlanVersion(version, committedAt, s.records[id], record)
+	if err != nil {
+		return err
+	}
+	if opts.Audit != nil {
+		entry, err := newAuditEntry(id, opts.Audit, version, committedAt, s.records[id], record)
+		if err != nil {
+			return err
+		}
+		entry.ID = strconv.Itoa(len(s.audit[id]) + 1)
+		s.audit[id] = append(s.audit[id], entry)
+	}
+
+	s.history[id] = append(s.history[id], planVersion)
+	s.versions[id] = version
+	if previous, exists := s.records[id]; record == nil && exists {
+		s.tombstones[id] = Tombstone{
+			DeletedAt: committedAt,
+			Version:   version,
+			Org:       recordOrg(previous),
+			Record:    previous,
+		}
+	}
+	if record == nil {
+		delete(s.records, id)
+	} else {
+		s.records[id] = record
+		delete(s.tombstones, id)
+	}
+	s.addEvents(stamped)
+	return nil
+}
+
+// addEvents must be called with s.mu held.
+func (s *MemoryRecordStore) addEvents(events []OutboxEvent) {
+	if len(events) == 0 {
+		return
+	}
+	for _, event := range events {
+		s.nextEventID++
+		event.ID = strconv.FormatInt(s.nextEventID, 10)
+		s.outbox = append(s.outbox, event)
+	}
+	select {
+	case s.eventAdded <- struct{}{}:
+	default:
+	}
+}
+
+func (s *MemoryRecordStore) Get(id string) (map[string]interface{}, error) {
+	s.mu.RLock()
+	jsonData, exists := s.records[id]
+	s.mu.RUnlock()
+	if !exists {
+		return nil, nil
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(jsonData, &record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *MemoryRecordStore) GetWithVersion(id string) (map[string]interface{}, int64, error) {
+	s.mu.RLock()
+	jsonData, exists := s.records[id]
+	version := s.versions[id]
+	s.mu.RUnlock()
+	if !exists {
+		return nil, version, nil
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(jsonData, &record); err != nil {
+		return nil, 0, err
+	}
+	return record, version, nil
+}
+
+func (s *MemoryRecordStore) GetAll() ([]map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []map[string]interface{}
+	for _, jsonData := range s.records {
+		var record map[string]interface{}
+		if err := json.Unmarshal(jsonData, &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// List returns records ordered by id; the cursor is the last id returned.
+func (s *MemoryRecordStore) List(cursor string, limit int, orgs ...string) (*RecordPage, error) {
+	after := ""
+	if cursor != "" {
+		raw, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = raw
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page := &RecordPage{Records: []map[string]interface{}{}}
+	ids := make([]string, 0, len(s.records))
+	for id, jsonData := range s.records {
+		if !listsAllOrgs(orgs) && !containsOrg(orgs, recordOrg(jsonData)) {
+			continue
+		}
+		page.Total++
+		if cursor == "" || id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for i, id := range ids {
+		if i == limit {
+			page.NextCursor = encodeCursor(ids[i-1])
+			break
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal(s.records[id], &record); err != nil {
+			continue
+		}
+		page.Records = append(page.Records, record)
+	}
+	return page, nil
+}
+
+func (s *MemoryRecordStore) Delete(id string, opts WriteOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(id, nil, opts)
+}
+
+func (s *MemoryRecordStore) GetDeleted(id string) (*Tombstone, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tombstone, exists := s.tombstones[id]
+	if !exists {
+		return nil, nil
+	}
+	return &tombstone, nil
+}
+
+func (s *MemoryRecordStore) Restore(id string, deletedVersion int64, opts WriteOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.records[id]; exists {
+		return ErrRecordExists
+	}
+	tombstone, exists := s.tombstones[id]
+	if !exists || tombstone.Version != deletedVersion {
+		return ErrNotDeleted
+	}
+	return s.write(id, tombstone.Record, opts)
+}
+
+func (s *MemoryRecordStore) PurgeDeleted(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, tombstone := range s.tombstones {
+		if tombstone.DeletedAt.Before(before) {
+			delete(s.tombstones, id)
+			delete(s.history, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// AuditLog returns entries in the order they were recorded; the cursor is the
+// number of entries already returned.
+func (s *MemoryRecordStore) AuditLog(id string, cursor string, limit int) (*AuditPage, error) {
+	offset := 0
+	if cursor != "" {
+		raw, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if offset, err = strconv.Atoi(raw); err != nil || offset < 0 {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page := &AuditPage{Entries: []AuditEntry{}}
+	entries := s.audit[id]
+	if offset >= len(entries) {
+		return page, nil
+	}
+	entries = entries[offset:]
+	if len(entries) > limit {
+		entries = entries[:limit]
+		page.NextCursor = encodeCursor(strconv.Itoa(offset + limit))
+	}
+	page.Entries = append(page.Entries, entries...)
+	return page, nil
+}
+
+// Versions pages by version number; the cursor is the last version returned.
+func (s *MemoryRecordStore) Versions(id string, cursor string, limit int) (*VersionPage, error) {
+	var after int64
+	if cursor != "" {
+		raw, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if after, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page := &VersionPage{Versions: []PlanVersion{}}
+	for _, version := range s.history[id] {
+		if version.Version <= after {
+			continue
+		}
+		if len(page.Versions) == limit {
+			page.NextCursor = encodeCursor(strconv.FormatInt(page.Versions[limit-1].Version, 10))
+			break
+		}
+		version.Record = nil
+		page.Versions = append(page.Versions, version)
+	}
+	return page, nil
+}
+
+func (s *MemoryRecordStore) GetVersion(id string, n int64) (*PlanVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, version := range s.history[id] {
+		if version.Version == n {
+			return &version, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryRecordStore) GetVersionAsOf(id string, t time.Time) (*PlanVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := s.history[id]
+	for i := len(history) - 1; i >= 0; i-- {
+		if !history[i].CommittedAt.After(t) {
+			version := history[i]
+			return &version, nil
+		}
+	}
+	return nil, nil
+}
+
+// ReadEvents returns the oldest unacknowledged events; consumer is ignored
+// since there is a single relay per process.
+func (s *MemoryRecordStore) ReadEvents(consumer string, count int, block time.Duration) ([]OutboxEvent, error) {
+	if events := s.pendingEvents(count); len(events) > 0 {
+		return events, nil
+	}
+
+	select {
+	case <-s.eventAdded:
+	case <-time.After(block):
+	}
+	return s.pendingEvents(count), nil
+}
+
+func (s *MemoryRecordStore) pendingEvents(count int) []OutboxEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if count > len(s.outbox) {
+		count = len(s.outbox)
+	}
+	return append([]OutboxEvent(nil), s.outbox[:count]...)
+}
+
+func (s *MemoryRecordStore) AckEvents(ids ...string) error {
+	acked := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		acked[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining := s.outbox[:0]
+	for _, event := range s.outbox {
+		if !acked[event.ID] {
+			remaining = append(remaining, event)
+		}
+	}
+	s.outbox = remaining
+	return nil
+}
+
+func (s *MemoryRecordStore) GetCheckpoint(name string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkpoints[name], nil
+}
+
+func (s *MemoryRecordStore) SetCheckpoint(name, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[name] = value
+	return nil
+}
+
+func (s *MemoryRecordStore) DeleteCheckpoint(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, name)
+	return nil
+}
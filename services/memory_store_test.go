@@ -0,0 +1,273 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"csye7255-project-one/config"
+)
+
+func saveRecord(t *testing.T, store *MemoryRecordStore, id, org string) {
+	t.Helper()
+	record := map[string]interface{}{"objectId": id, "_org": org}
+	if err := store.Save(id, record, WriteOptions{}); err != nil {
+		t.Fatalf("save %s: %v", id, err)
+	}
+}
+
+func TestMemoryRecordStoreList(t *testing.T) {
+	store := NewMemoryRecordStore()
+	saveRecord(t, store, "d", "org1")
+	saveRecord(t, store, "a", "org1")
+	saveRecord(t, store, "c", "org1")
+	saveRecord(t, store, "b", "org2")
+	saveRecord(t, store, "e", "org2")
+	if err := store.Delete("e", WriteOptions{}); err != nil {
+		t.Fatalf("delete e: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		orgs      []string
+		limit     int
+		wantPages [][]string
+		wantTotal int64
+	}{
+		{"all records", nil, 10, [][]string{{"a", "b", "c", "d"}}, 4},
+		{"all orgs", []string{config.AllOrgs}, 3, [][]string{{"a", "b", "c"}, {"d"}}, 4},
+		{"exact pages", nil, 2, [][]string{{"a", "b"}, {"c", "d"}}, 4},
+		{"one org", []string{"org1"}, 2, [][]string{{"a", "c"}, {"d"}}, 3},
+		{"several orgs", []string{"org2", "org1"}, 3, [][]string{{"a", "b", "c"}, {"d"}}, 4},
+		{"unknown org", []string{"org3"}, 2, [][]string{{}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages [][]string
+			cursor := ""
+			for {
+				page, err := store.List(cursor, tt.limit, tt.orgs...)
+				if err != nil {
+					t.Fatalf("list: %v", err)
+				}
+				if page.Total != tt.wantTotal {
+					t.Errorf("total = %d, want %d", page.Total, tt.wantTotal)
+				}
+				ids := []string{}
+				for _, record := range page.Records {
+					ids = append(ids, record["objectId"].(string))
+				}
+				pages = append(pages, ids)
+				if page.NextCursor == "" || len(pages) > len(tt.wantPages) {
+					break
+				}
+				cursor = page.NextCursor
+			}
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+			}
+		})
+	}
+
+	if _, err := store.List("not a cursor!", 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("invalid cursor: got error %v, want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestMemoryRecordStoreVersions(t *testing.T) {
+	store := NewMemoryRecordStore()
+	for i := 0; i < 3; i++ {
+		saveRecord(t, store, "plan", "org1")
+	}
+	if err := store.Delete("plan", WriteOptions{}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		limit     int
+		wantPages [][]int64
+	}{
+		{"single page", 10, [][]int64{{1, 2, 3, 4}}},
+		{"exact pages", 2, [][]int64{{1, 2}, {3, 4}}},
+		{"partial last page", 3, [][]int64{{1, 2, 3}, {4}}},
+		{"one per page", 1, [][]int64{{1}, {2}, {3}, {4}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages [][]int64
+			cursor := ""
+			for {
+				page, err := store.Versions("plan", cursor, tt.limit)
+				if err != nil {
+					t.Fatalf("versions: %v", err)
+				}
+				numbers := []int64{}
+				for _, version := range page.Versions {
+					if version.Record != nil {
+						t.Errorf("version %d: listing includes the record", version.Version)
+					}
+					if version.Deleted != (version.Version == 4) {
+						t.Errorf("version %d: Deleted = %v", version.Version, version.Deleted)
+					}
+					numbers = append(numbers, version.Version)
+				}
+				pages = append(pages, numbers)
+				if page.NextCursor == "" || len(pages) > len(tt.wantPages) {
+					break
+				}
+				cursor = page.NextCursor
+			}
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+			}
+		})
+	}
+
+	page, err := store.Versions("missing", "", 10)
+	if err != nil || len(page.Versions) != 0 || page.NextCursor != "" {
+		t.Errorf("unknown id: got %+v, %v, want an empty page", page, err)
+	}
+}
+
+func TestMemoryRecordStoreRestore(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup leaves "plan" in the state under test and returns the version
+		// to restore.
+		setup   func(t *testing.T, store *MemoryRecordStore) int64
+		wantErr error
+	}{
+		{
+			name: "deleted record",
+			setup: func(t *testing.T, store *MemoryRecordStore) int64 {
+				saveRecord(t, store, "plan", "org1")
+				store.Delete("plan", WriteOptions{})
+				return 2
+			},
+		},
+		{
+			name: "live record",
+			setup: func(t *testing.T, store *MemoryRecordStore) int64 {
+				saveRecord(t, store, "plan", "org1")
+				return 1
+			},
+			wantErr: ErrRecordExists,
+		},
+		{
+			name: "never existed",
+			setup: func(t *testing.T, store *MemoryRecordStore) int64 {
+				return 1
+			},
+			wantErr: ErrNotDeleted,
+		},
+		{
+			name: "stale delete version",
+			setup: func(t *testing.T, store *MemoryRecordStore) int64 {
+				saveRecord(t, store, "plan", "org1")
+				store.Delete("plan", WriteOptions{})
+				return 1
+			},
+			wantErr: ErrNotDeleted,
+		},
+		{
+			name: "already restored and deleted again",
+			setup: func(t *testing.T, store *MemoryRecordStore) int64 {
+				saveRecord(t, store, "plan", "org1")
+				store.Delete("plan", WriteOptions{})
+				store.Restore("plan", 2, WriteOptions{})
+				store.Delete("plan", WriteOptions{})
+				return 2
+			},
+			wantErr: ErrNotDeleted,
+		},
+		{
+			name: "purged",
+			setup: func(t *testing.T, store *MemoryRecordStore) int64 {
+				saveRecord(t, store, "plan", "org1")
+				store.Delete("plan", WriteOptions{})
+				store.PurgeDeleted(time.Now().Add(time.Minute))
+				return 2
+			},
+			wantErr: ErrNotDeleted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryRecordStore()
+			deletedVersion := tt.setup(t, store)
+
+			err := store.Restore("plan", deletedVersion, WriteOptions{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			record, version, err := store.GetWithVersion("plan")
+			if err != nil || record["_org"] != "org1" || version != deletedVersion+1 {
+				t.Errorf("got %v at version %d (%v), want the org1 record at version %d", record, version, err, deletedVersion+1)
+			}
+			if tombstone, _ := store.GetDeleted("plan"); tombstone != nil {
+				t.Errorf("tombstone kept after restore: %+v", tombstone)
+			}
+		})
+	}
+}
+
+func TestMemoryRecordStoreWriteConflicts(t *testing.T) {
+	version := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name    string
+		write   func(store *MemoryRecordStore) error
+		wantErr error
+	}{
+		{
+			name: "save at current version",
+			write: func(store *MemoryRecordStore) error {
+				return store.Save("plan", map[string]interface{}{}, WriteOptions{IfVersion: version(2)})
+			},
+		},
+		{
+			name: "save at stale version",
+			write: func(store *MemoryRecordStore) error {
+				return store.Save("plan", map[string]interface{}{}, WriteOptions{IfVersion: version(1)})
+			},
+			wantErr: ErrVersionConflict,
+		},
+		{
+			name: "delete at stale version",
+			write: func(store *MemoryRecordStore) error {
+				return store.Delete("plan", WriteOptions{IfVersion: version(3)})
+			},
+			wantErr: ErrVersionConflict,
+		},
+		{
+			name: "create over restorable tombstone",
+			write: func(store *MemoryRecordStore) error {
+				return store.Save("deleted", map[string]interface{}{}, WriteOptions{})
+			},
+			wantErr: ErrRecordDeleted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryRecordStore()
+			saveRecord(t, store, "plan", "org1")
+			saveRecord(t, store, "plan", "org1")
+			saveRecord(t, store, "deleted", "org1")
+			store.Delete("deleted", WriteOptions{})
+
+			if err := tt.write(store); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
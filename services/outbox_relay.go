@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+var (
+	outboxBatchSize = 100
+	outboxBlock     = 2 * time.Second
+	outboxRetry     = 5 * time.Second
+)
+
+// RunOutboxRelay publishes outbox events to RabbitMQ until ctx is cancelled.
+// An event is acknowledged only after the broker confirmed it, so a crash or
+// publish failure leads to redelivery rather than loss. After a failure the
+// rest of the batch stays pending and is read again, failed event first,
+// before any newer event, which keeps the events of a record in order.
+func RunOutboxRelay(ctx context.Context, outbox Outbox, consumer string) {
+	log.Printf("Outbox relay started as consumer: %s", consumer)
+	for ctx.Err() == nil {
+		events, err := outbox.ReadEvents(consumer, outboxBatchSize, outboxBlock)
+		if err != nil {
+			log.Printf("Failed to read outbox events: %v", err)
+			sleepContext(ctx, outboxRetry)
+			continue
+		}
+
+		for _, event := range events {
+			if err := PublishMessage(event.Queue, event.Message); err != nil {
+				log.Printf("Failed to relay outbox event %s, will retry: %v", event.ID, err)
+				sleepContext(ctx, outboxRetry)
+				break
+			}
+			if err := outbox.AckEvents(event.ID); err != nil {
+				log.Printf("Failed to ack outbox event %s: %v", event.ID, err)
+			}
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
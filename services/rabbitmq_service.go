@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"csye7255-project-one/config"
 	"csye7255-project-one/models"
@@ -12,36 +13,81 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func PublishMessage(queueName string, message []byte) error {
-	ch, err := config.GetRabbitMQChannel()
-	if err != nil {
-		log.Printf("Failed to get RabbitMQ channel: %v", err)
-		return err
+var (
+	maxDeliveryAttempts = 5
+	retryBaseDelay      = time.Second
+	retryMaxDelay       = 30 * time.Second
+
+	// A consumer whose delivery channel closed starts again after these delays
+	reconsumeBaseDelay = time.Second
+	reconsumeMaxDelay  = time.Minute
+)
+
+// deadLetterQueueName is where messages that exhaust their retries are parked.
+func deadLetterQueueName(queueName string) string {
+	return queueName + ".dlq"
+}
+
+// declareQueues declares the durable work queue and its dead-letter queue.
+// An existing non-durable queue of the same name must be deleted first, since
+// RabbitMQ refuses to redeclare a queue with different properties.
+func declareQueues(ch *amqp.Channel, queueName string) (amqp.Queue, error) {
+	if _, err := ch.QueueDeclare(
+		deadLetterQueueName(queueName),
+		true,  // durable
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	); err != nil {
+		return amqp.Queue{}, err
 	}
-	defer ch.Close()
 
-	q, err := ch.QueueDeclare(
+	return ch.QueueDeclare(
 		queueName,
-		false, // durable
+		true,  // durable
 		false, // auto-delete
 		false, // exclusive
 		false, // no-wait
 		nil,   // arguments
 	)
+}
+
+// PublishMessage publishes a persistent message and waits for the broker to
+// confirm it, so a nil error means RabbitMQ has taken responsibility for it.
+func PublishMessage(queueName string, message []byte) error {
+	return publishWithHeaders(queueName, message, nil)
+}
+
+func publishWithHeaders(queueName string, message []byte, headers amqp.Table) error {
+	ch, err := config.GetRabbitMQChannel()
 	if err != nil {
+		log.Printf("Failed to get RabbitMQ channel: %v", err)
+		return err
+	}
+	defer ch.Close()
+
+	if _, err := declareQueues(ch, queueName); err != nil {
 		log.Printf("Failed to declare RabbitMQ queue: %v", err)
 		return err
 	}
 
+	if err := ch.Confirm(false); err != nil {
+		log.Printf("Failed to enable publisher confirms: %v", err)
+		return err
+	}
+
 	// Publish the message
-	err = ch.Publish(
-		"",     // exchange
-		q.Name, // routing key
-		false,  // mandatory
-		false,  // immediate
+	confirmation, err := ch.PublishWithDeferredConfirm(
+		"",        // exchange
+		queueName, // routing key
+		false,     // mandatory
+		false,     // immediate
 		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        message,
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Headers:      headers,
+			Body:         message,
 		},
 	)
 	if err != nil {
@@ -49,35 +95,70 @@ func PublishMessage(queueName string, message []byte) error {
 		return err
 	}
 
+	if !confirmation.Wait() {
+		log.Printf("RabbitMQ did not confirm message on queue: %s", queueName)
+		return fmt.Errorf("message was not confirmed by RabbitMQ on queue %s", queueName)
+	}
+
 	log.Printf("Message successfully published to RabbitMQ queue: %s", queueName)
 	return nil
 }
 
+// ConsumeMessages delivers each message to handler and only acks it once the
+// handler succeeds. Failures are retried with exponential backoff; messages
+// that still fail after maxDeliveryAttempts are moved to the dead-letter queue
+// with the failure reason in their headers. When the delivery channel closes,
+// e.g. after a broker restart, it consumes again with backoff, so it only
+// returns when the consumer cannot be set up in the first place.
 func ConsumeMessages(queueName string, handler func([]byte) error) error {
+	ch, msgs, err := startConsumer(queueName)
+	if err != nil {
+		return err
+	}
+
+	for {
+		handleDeliveries(queueName, msgs, handler)
+		ch.Close()
+
+		delay := reconsumeBaseDelay
+		log.Printf("RabbitMQ delivery channel of %s closed, consuming again in %s", queueName, delay)
+		for {
+			time.Sleep(delay)
+			if ch, msgs, err = startConsumer(queueName); err == nil {
+				break
+			}
+			delay = backoff(delay, reconsumeMaxDelay)
+			log.Printf("Failed to consume RabbitMQ queue %s again, retrying in %s", queueName, delay)
+		}
+	}
+}
+
+// startConsumer opens a channel and starts consuming queueName on it.
+func startConsumer(queueName string) (*amqp.Channel, <-chan amqp.Delivery, error) {
 	ch, err := config.GetRabbitMQChannel()
 	if err != nil {
 		log.Printf("Failed to get RabbitMQ channel: %v", err)
-		return err
+		return nil, nil, err
 	}
-	defer ch.Close()
 
-	q, err := ch.QueueDeclare(
-		queueName,
-		false, // durable
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
+	q, err := declareQueues(ch, queueName)
 	if err != nil {
 		log.Printf("Failed to declare RabbitMQ queue: %v", err)
-		return err
+		ch.Close()
+		return nil, nil, err
+	}
+
+	// Handle one message at a time so retries keep their ordering
+	if err := ch.Qos(1, 0, false); err != nil {
+		log.Printf("Failed to set RabbitMQ prefetch: %v", err)
+		ch.Close()
+		return nil, nil, err
 	}
 
 	msgs, err := ch.Consume(
 		q.Name,
 		"",    // consumer tag
-		true,  // auto-ack
+		false, // auto-ack
 		false, // exclusive
 		false, // no-local
 		false, // no-wait
@@ -85,21 +166,74 @@ func ConsumeMessages(queueName string, handler func([]byte) error) error {
 	)
 	if err != nil {
 		log.Printf("Failed to register RabbitMQ consumer: %v", err)
-		return err
+		ch.Close()
+		return nil, nil, err
 	}
 
-	go func() {
-		for d := range msgs {
-			log.Printf("Received message from RabbitMQ queue: %s", queueName)
+	log.Printf("Listening for messages on RabbitMQ queue: %s", queueName)
+	return ch, msgs, nil
+}
+
+// handleDeliveries processes msgs until the delivery channel closes.
+func handleDeliveries(queueName string, msgs <-chan amqp.Delivery, handler func([]byte) error) {
+	for d := range msgs {
+		log.Printf("Received message from RabbitMQ queue: %s", queueName)
 
-			if err := handler(d.Body); err != nil {
-				log.Printf("Failed to process message: %v", err)
+		attempts, err := handleWithRetry(d.Body, handler)
+		if err == nil {
+			if err := d.Ack(false); err != nil {
+				log.Printf("Failed to ack message: %v", err)
 			}
+			continue
 		}
-	}()
 
-	log.Printf("Listening for messages on RabbitMQ queue: %s", queueName)
-	select {} // Block forever
+		log.Printf("Failed to process message after %d attempts, dead-lettering: %v", attempts, err)
+		headers := amqp.Table{
+			"x-failure-reason": err.Error(),
+			"x-attempts":       int32(attempts),
+			"x-original-queue": queueName,
+			"x-failed-at":      time.Now().UTC().Format(time.RFC3339),
+		}
+		if err := publishWithHeaders(deadLetterQueueName(queueName), d.Body, headers); err != nil {
+			log.Printf("Failed to dead-letter message, requeueing: %v", err)
+			if err := d.Nack(false, true); err != nil {
+				log.Printf("Failed to nack message: %v", err)
+			}
+			continue
+		}
+		if err := d.Ack(false); err != nil {
+			log.Printf("Failed to ack dead-lettered message: %v", err)
+		}
+	}
+}
+
+// handleWithRetry runs handler until it succeeds or maxDeliveryAttempts is
+// reached, doubling the delay between attempts up to retryMaxDelay.
+func handleWithRetry(body []byte, handler func([]byte) error) (int, error) {
+	delay := retryBaseDelay
+	var err error
+	for attempt := 1; attempt <= maxDeliveryAttempts; attempt++ {
+		if err = handler(body); err == nil {
+			return attempt, nil
+		}
+		if attempt == maxDeliveryAttempts {
+			return attempt, err
+		}
+
+		log.Printf("Failed to process message (attempt %d/%d), retrying in %s: %v", attempt, maxDeliveryAttempts, delay, err)
+		time.Sleep(delay)
+		delay = backoff(delay, retryMaxDelay)
+	}
+	return maxDeliveryAttempts, err
+}
+
+// backoff doubles delay up to limit.
+func backoff(delay, limit time.Duration) time.Duration {
+	delay *= 2
+	if delay > limit {
+		return limit
+	}
+	return delay
 }
 
 func ProcessMessage(message []byte) error {
@@ -108,13 +242,50 @@ func ProcessMessage(message []byte) error {
 		return errors.New("failed to deserialize message: " + err.Error())
 	}
 
-	operation := msg["operation"].(string)
-	index := msg["index"].(string)
-	docID := msg["doc_id"].(string)
+	operation, _ := msg["operation"].(string)
+	index, _ := msg["index"].(string)
+	docID, _ := msg["doc_id"].(string)
+	if operation == "" || index == "" || docID == "" {
+		return errors.New("message is missing operation, index or doc_id")
+	}
 	var payload map[string]interface{}
 	if msgPayload, ok := msg["payload"]; ok && msgPayload != nil {
 		payload, _ = msgPayload.(map[string]interface{})
 	}
+	// Messages published before versioning carry no version and are
+	// indexed unversioned
+	versionNumber, _ := msg["version"].(float64)
+	version := int64(versionNumber)
+
+	resource, _ := msg["resource"].(string)
+	childID, _ := msg["child_id"].(string)
+
+	if err := ensureIndex(index); err != nil {
+		return fmt.Errorf("failed to ensure index %s exists: %v", index, err)
+	}
+	if err := applyOperation(operation, index, docID, resource, childID, payload, version); err != nil {
+		return err
+	}
+
+	// Keep an index being rebuilt by a reindex up to date as well
+	target, err := reindexTarget(index)
+	if err != nil {
+		return fmt.Errorf("failed to look up reindex target of %s: %v", index, err)
+	}
+	if target != "" && target != index {
+		if err := applyOperation(operation, target, docID, resource, childID, payload, version); err != nil {
+			return fmt.Errorf("failed to apply operation to reindex target %s: %v", target, err)
+		}
+	}
+
+	log.Printf("Successfully processed %s operation for document ID: %s at version %d", operation, docID, version)
+	return nil
+}
+
+func applyOperation(operation, index, docID, resource, childID string, payload map[string]interface{}, version int64) error {
+	if resource != "" {
+		return processChildOperation(operation, index, docID, resource, childID, payload, version)
+	}
 
 	switch operation {
 	case "POST":
@@ -122,7 +293,7 @@ func ProcessMessage(message []byte) error {
 		if err := mapToStruct(payload, &plan); err != nil {
 			return fmt.Errorf("failed to map payload to Plan struct: %v", err)
 		}
-		if err := SaveParentAndChildrenToElasticsearch(index, plan); err != nil {
+		if err := SaveParentAndChildrenToElasticsearch(index, plan, version); err != nil {
 			return fmt.Errorf("failed to save parent and children to Elasticsearch: %v", err)
 		}
 	case "PUT":
@@ -130,7 +301,7 @@ func ProcessMessage(message []byte) error {
 		if err := mapToStruct(payload, &plan); err != nil {
 			return fmt.Errorf("failed to map payload to Plan struct: %v", err)
 		}
-		if err := SaveParentAndChildrenToElasticsearch(index, plan); err != nil {
+		if err := SaveParentAndChildrenToElasticsearch(index, plan, version); err != nil {
 			return fmt.Errorf("failed to update parent and children in Elasticsearch: %v", err)
 		}
 	case "PATCH":
@@ -138,18 +309,51 @@ func ProcessMessage(message []byte) error {
 		if err := mapToStruct(payload, &plan); err != nil {
 			return fmt.Errorf("failed to map payload to Plan struct: %v", err)
 		}
-		if err := PatchParentAndChildren(index, plan); err != nil {
+		if err := PatchParentAndChildren(index, plan, version); err != nil {
 			return fmt.Errorf("failed to patch parent and children in Elasticsearch: %v", err)
 		}
 	case "DELETE":
-		if err := DeleteParentAndChildren(index, docID); err != nil {
+		if err := DeleteParentAndChildren(index, docID, version); err != nil {
 			return fmt.Errorf("failed to delete parent and children from Elasticsearch: %v", err)
 		}
 	default:
 		return fmt.Errorf("unknown operation: %s", operation)
 	}
+	return nil
+}
 
-	log.Printf("Successfully processed %s operation for document ID: %s", operation, docID)
+// processChildOperation applies a sub-resource change to the plan's subtree
+// only. Any operation other than DELETE rewrites the subtree from payload.
+func processChildOperation(operation, index, planID, resource, childID string, payload map[string]interface{}, version int64) error {
+	switch resource {
+	case "planCostShares":
+		if operation == "DELETE" {
+			return fmt.Errorf("planCostShares of %s cannot be deleted", planID)
+		}
+		costShares := models.PlanCostShares{}
+		if err := mapToStruct(payload, &costShares); err != nil {
+			return fmt.Errorf("failed to map payload to PlanCostShares struct: %v", err)
+		}
+		if err := SavePlanCostShares(index, planID, costShares, version); err != nil {
+			return fmt.Errorf("failed to save planCostShares to Elasticsearch: %v", err)
+		}
+	case "linkedPlanServices":
+		if operation == "DELETE" {
+			if err := DeleteLinkedPlanService(index, planID, childID, version); err != nil {
+				return fmt.Errorf("failed to delete linkedPlanService from Elasticsearch: %v", err)
+			}
+			return nil
+		}
+		linkedPlanService := models.LinkedPlanService{}
+		if err := mapToStruct(payload, &linkedPlanService); err != nil {
+			return fmt.Errorf("failed to map payload to LinkedPlanService struct: %v", err)
+		}
+		if err := SaveLinkedPlanService(index, planID, linkedPlanService, version); err != nil {
+			return fmt.Errorf("failed to save linkedPlanService to Elasticsearch: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown resource: %s", resource)
+	}
 	return nil
 }
 
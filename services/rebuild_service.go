@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"csye7255-project-one/models"
+)
+
+const defaultRebuildWorkers = 4
+
+type RebuildOptions struct {
+	// Workers bounds the number of plans indexed concurrently.
+	Workers int
+	// Resume continues after the last page a previous rebuild completed.
+	Resume bool
+	// Progress is called after every page.
+	Progress func(RebuildProgress)
+}
+
+type RebuildProgress struct {
+	Index     string    `json:"index"`
+	StartedAt time.Time `json:"startedAt"`
+	Resumed   bool      `json:"resumed"`
+	Total     int64     `json:"total"`
+	Indexed   int       `json:"indexed"`
+	Failed    []string  `json:"failed"`
+	Cursor    string    `json:"cursor"`
+	Done      bool      `json:"done"`
+}
+
+func rebuildCheckpoint(index string) string {
+	return "rebuild:" + index
+}
+
+// Rebuild indexes every record in store into index, or the index of its org
+// with per-org indices, with the same logic the consumer uses, a page at a
+// time. The cursor after each completed page is
+// saved as a checkpoint, so a rebuild that stopped can resume from it. Plans
+// that fail to index are reported rather than stopping the rebuild.
+func Rebuild(ctx context.Context, store RecordStore, index string, opts RebuildOptions) (*RebuildProgress, error) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultRebuildWorkers
+	}
+	progress := &RebuildProgress{Index: index, StartedAt: time.Now().UTC(), Failed: []string{}}
+
+	if err := CreateIndexIfNotExists(index); err != nil {
+		return progress, err
+	}
+
+	checkpoint := rebuildCheckpoint(index)
+	if opts.Resume {
+		cursor, err := store.GetCheckpoint(checkpoint)
+		if err != nil {
+			return progress, fmt.Errorf("failed to read rebuild checkpoint: %v", err)
+		}
+		progress.Cursor = cursor
+		progress.Resumed = cursor != ""
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		page, err := store.List(progress.Cursor, reconcilePageSize)
+		if err != nil {
+			return progress, fmt.Errorf("failed to list records: %v", err)
+		}
+		progress.Total = page.Total
+
+		indexed, failed := rebuildPage(store, index, page.Records, workers)
+		progress.Indexed += indexed
+		progress.Failed = append(progress.Failed, failed...)
+		progress.Cursor = page.NextCursor
+
+		if page.NextCursor == "" {
+			progress.Done = true
+		} else if err := store.SetCheckpoint(checkpoint, page.NextCursor); err != nil {
+			return progress, fmt.Errorf("failed to save rebuild checkpoint: %v", err)
+		}
+		if opts.Progress != nil {
+			opts.Progress(*progress)
+		}
+		if progress.Done {
+			break
+		}
+	}
+
+	if err := store.DeleteCheckpoint(checkpoint); err != nil {
+		log.Printf("Failed to clear rebuild checkpoint: %v", err)
+	}
+	return progress, nil
+}
+
+// rebuildPage indexes records with at most workers plans in flight and returns
+// the number indexed and the IDs that failed.
+func rebuildPage(store RecordStore, index string, records []map[string]interface{}, workers int) (int, []string) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		indexed int
+		failed  []string
+	)
+	ids := make(chan string)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				ok, err := rebuildPlan(store, index, id)
+				mu.Lock()
+				if err != nil {
+					log.Printf("Failed to rebuild plan %s: %v", id, err)
+					failed = append(failed, id)
+				} else if ok {
+					indexed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, record := range records {
+		id, _ := record["objectId"].(string)
+		ids <- id
+	}
+	close(ids)
+	wg.Wait()
+
+	return indexed, failed
+}
+
+// rebuildPlan re-reads id with its version, so a change made since the page
+// was listed is not overwritten with older data. It reports false when the
+// plan was deleted in the meantime.
+func rebuildPlan(store RecordStore, index, id string) (bool, error) {
+	record, version, err := store.GetWithVersion(id)
+	if err != nil {
+		return false, err
+	}
+	if record == nil {
+		return false, nil
+	}
+
+	var plan models.Plan
+	if err := mapToStruct(record, &plan); err != nil {
+		return false, err
+	}
+	orgIndex := IndexForOrg(index, plan.Org)
+	if err := ensureIndex(orgIndex); err != nil {
+		return false, err
+	}
+	if err := SaveParentAndChildrenToElasticsearch(orgIndex, plan, version); err != nil {
+		return false, err
+	}
+	return true, nil
+}
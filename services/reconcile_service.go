@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"csye7255-project-one/config"
+	"csye7255-project-one/models"
+)
+
+const reconcilePageSize = 100
+
+type DriftKind string
+
+const (
+	// DriftMissing is a document Redis implies but the index lacks.
+	DriftMissing DriftKind = "missing"
+	// DriftStale is an indexed document whose content differs from Redis.
+	DriftStale DriftKind = "stale"
+	// DriftOrphaned is an indexed document with no counterpart in Redis.
+	DriftOrphaned DriftKind = "orphaned"
+)
+
+type Drift struct {
+	PlanID string    `json:"planId"`
+	DocID  string    `json:"docId"`
+	Kind   DriftKind `json:"kind"`
+}
+
+type ReconcileReport struct {
+	StartedAt     time.Time `json:"startedAt"`
+	PlansChecked  int       `json:"plansChecked"`
+	Drifts        []Drift   `json:"drifts"`
+	PlansRepaired int       `json:"plansRepaired"`
+}
+
+// Reconcile compares every record in store with its documents in index and
+// reports the differences. With repair set, drifted plans are re-indexed from
+// the store, orphaned child documents are deleted, and indexed plans missing
+// from the store are removed with DeleteParentAndChildren. Changes still in
+// flight through the queue can show up as drift.
+func Reconcile(store RecordStore, index string, repair bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{StartedAt: time.Now().UTC(), Drifts: []Drift{}}
+
+	cursor := ""
+	for {
+		page, err := store.List(cursor, reconcilePageSize)
+		if err != nil {
+			return report, fmt.Errorf("failed to list records: %v", err)
+		}
+
+		for _, record := range page.Records {
+			var plan models.Plan
+			if err := mapToStruct(record, &plan); err != nil {
+				return report, fmt.Errorf("failed to parse record: %v", err)
+			}
+			if err := reconcilePlan(store, IndexForOrg(index, plan.Org), plan, repair, report); err != nil {
+				return report, err
+			}
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if err := reconcileOrphanedPlans(store, SearchIndex(index, []string{config.AllOrgs}), repair, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func reconcilePlan(store RecordStore, index string, plan models.Plan, repair bool, report *ReconcileReport) error {
+	report.PlansChecked++
+
+	indexed, err := fetchPlanDocuments(index, plan.ObjectId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch documents of plan %s: %v", plan.ObjectId, err)
+	}
+
+	var drifts []Drift
+	expected := make(map[string]bool)
+	for _, doc := range planDocuments(plan) {
+		expected[doc.ID] = true
+
+		hit, ok := indexed[doc.ID]
+		if !ok {
+			drifts = append(drifts, Drift{PlanID: plan.ObjectId, DocID: doc.ID, Kind: DriftMissing})
+			continue
+		}
+		if !sameDocument(doc.Body, hit.Source) {
+			drifts = append(drifts, Drift{PlanID: plan.ObjectId, DocID: doc.ID, Kind: DriftStale})
+		}
+	}
+
+	for id := range indexed {
+		if !expected[id] {
+			drifts = append(drifts, Drift{PlanID: plan.ObjectId, DocID: id, Kind: DriftOrphaned})
+		}
+	}
+
+	if len(drifts) == 0 {
+		return nil
+	}
+	report.Drifts = append(report.Drifts, drifts...)
+	log.Printf("Plan %s has %d drifted documents", plan.ObjectId, len(drifts))
+
+	if !repair {
+		return nil
+	}
+	// Re-read with the version so the repair cannot overwrite a newer change
+	// that the consumer indexes concurrently
+	record, version, err := store.GetWithVersion(plan.ObjectId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch record %s: %v", plan.ObjectId, err)
+	}
+	if record == nil {
+		return nil
+	}
+	if err := mapToStruct(record, &plan); err != nil {
+		return fmt.Errorf("failed to parse record: %v", err)
+	}
+	if err := SaveParentAndChildrenToElasticsearch(index, plan, version); err != nil {
+		return fmt.Errorf("failed to repair plan %s: %v", plan.ObjectId, err)
+	}
+	report.PlansRepaired++
+	return nil
+}
+
+// fetchPlanDocuments returns every indexed document of a plan's subtree,
+// keyed by id.
+func fetchPlanDocuments(index, planID string) (map[string]esHit, error) {
+	docs := make(map[string]esHit)
+
+	hits, err := searchDocuments(index, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"ids": map[string]interface{}{"values": []string{planID}}},
+				parentIDQuery("planCostShares", planID),
+				parentIDQuery("linkedPlanServices", planID),
+			},
+		},
+	}, 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	var grandchildQueries []interface{}
+	for _, hit := range hits {
+		docs[hit.ID] = hit
+		if relationName(hit.Source) == "linkedPlanServices" {
+			grandchildQueries = append(grandchildQueries,
+				parentIDQuery("linkedService", hit.ID),
+				parentIDQuery("planServiceCostShares", hit.ID),
+			)
+		}
+	}
+	if len(grandchildQueries) == 0 {
+		return docs, nil
+	}
+
+	hits, err = searchDocuments(index, map[string]interface{}{
+		"bool": map[string]interface{}{"should": grandchildQueries},
+	}, 1000)
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range hits {
+		docs[hit.ID] = hit
+	}
+	return docs, nil
+}
+
+// reconcileOrphanedPlans finds indexed plans that no longer exist in store.
+// index may be a pattern; orphans are deleted from the index they were found
+// in.
+func reconcileOrphanedPlans(store RecordStore, index string, repair bool, report *ReconcileReport) error {
+	type orphan struct {
+		index   string
+		version int64
+	}
+	orphaned := make(map[string]orphan)
+	err := scrollDocuments(index, relationTerm("plan"), reconcilePageSize, func(hits []esHit) error {
+		for _, hit := range hits {
+			record, version, err := store.GetWithVersion(hit.ID)
+			if err != nil {
+				return fmt.Errorf("failed to check record %s: %v", hit.ID, err)
+			}
+			if record == nil {
+				orphaned[hit.ID] = orphan{index: hit.Index, version: version}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for planID, o := range orphaned {
+		report.Drifts = append(report.Drifts, Drift{PlanID: planID, DocID: planID, Kind: DriftOrphaned})
+		log.Printf("Plan %s is indexed in %s but not stored", planID, o.index)
+		if !repair {
+			continue
+		}
+		if err := DeleteParentAndChildren(o.index, planID, o.version); err != nil {
+			return fmt.Errorf("failed to delete orphaned plan %s: %v", planID, err)
+		}
+		report.PlansRepaired++
+	}
+	return nil
+}
+
+// RunReconcileSchedule runs Reconcile every interval until ctx is cancelled.
+func RunReconcileSchedule(ctx context.Context, store RecordStore, index string, interval time.Duration, repair bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := Reconcile(store, index, repair)
+		if err != nil {
+			log.Printf("Reconciliation failed: %v", err)
+			continue
+		}
+		log.Printf("Reconciliation checked %d plans, found %d drifted documents, repaired %d plans",
+			report.PlansChecked, len(report.Drifts), report.PlansRepaired)
+	}
+}
+
+func parentIDQuery(childType, parentID string) map[string]interface{} {
+	return map[string]interface{}{
+		"parent_id": map[string]interface{}{
+			"type": childType,
+			"id":   parentID,
+		},
+	}
+}
+
+func relationName(source map[string]interface{}) string {
+	relation, _ := source["relation"].(map[string]interface{})
+	name, _ := relation["name"].(string)
+	return name
+}
+
+// sameDocument compares an expected document with an indexed _source after
+// normalising both through JSON.
+func sameDocument(expected, indexed map[string]interface{}) bool {
+	expectedJSON, err := json.Marshal(expected)
+	if err != nil {
+		return false
+	}
+	var normalised map[string]interface{}
+	if err := json.Unmarshal(expectedJSON, &normalised); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(normalised, indexed)
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"csye7255-project-one/config"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrNotDeleted    = errors.New("record is not deleted")
+	ErrRecordExists  = errors.New("record already exists")
+	// ErrRecordDeleted rejects creating a record over a tombstone that can
+	// still be restored.
+	ErrRecordDeleted = errors.New("record is deleted and can still be restored")
+	// ErrVersionConflict rejects a write whose WriteOptions.IfVersion is no
+	// longer the record's version.
+	ErrVersionConflict = errors.New("record version changed")
+)
+
+// RecordStore persists plan records keyed by their objectId. Every Save and
+// Delete bumps a per-record version that only ever increases, even across
+// deletes, and every committed version is kept. Get returns a nil record and a
+// nil error when the id does not exist. The events and audit entry passed to
+// Save and Delete in WriteOptions are written atomically with the change.
+type RecordStore interface {
+	Outbox
+	Checkpoints
+	Exists(id string) (bool, error)
+	Save(id string, data interface{}, opts WriteOptions) error
+	Get(id string) (map[string]interface{}, error)
+	// GetWithVersion reads a record and its current version atomically.
+	GetWithVersion(id string) (map[string]interface{}, int64, error)
+	GetAll() ([]map[string]interface{}, error)
+	// List pages through the records whose _org is one of orgs. Without
+	// orgs, or with config.AllOrgs among them, it lists every record.
+	List(cursor string, limit int, orgs ...string) (*RecordPage, error)
+	// Delete moves the record to a tombstone, from which Restore brings it
+	// back until PurgeDeleted removes it. Until the tombstone expires, Save
+	// fails with ErrRecordDeleted for that id.
+	Delete(id string, opts WriteOptions) error
+	// GetDeleted returns the tombstone of id, or nil when it has none.
+	GetDeleted(id string) (*Tombstone, error)
+	// Restore brings back the record deleted at version deletedVersion. It
+	// fails with ErrRecordExists when id exists again and with ErrNotDeleted
+	// when that tombstone is gone.
+	Restore(id string, deletedVersion int64, opts WriteOptions) error
+	// PurgeDeleted permanently removes the tombstones of records deleted
+	// before the given time, along with their version history. Audit logs
+	// are kept. It returns the number of records purged.
+	PurgeDeleted(before time.Time) (int, error)
+	// AuditLog pages through the audit entries of id, oldest first. Entries
+	// outlive the record they describe.
+	AuditLog(id string, cursor string, limit int) (*AuditPage, error)
+	// Versions pages through the committed versions of id, oldest first.
+	Versions(id string, cursor string, limit int) (*VersionPage, error)
+	// GetVersion returns version n of id, or nil when it was not kept.
+	GetVersion(id string, n int64) (*PlanVersion, error)
+	// GetVersionAsOf returns the version of id that was current at t, or nil
+	// when none was.
+	GetVersionAsOf(id string, t time.Time) (*PlanVersion, error)
+}
+
+// WriteOptions accompany a Save or Delete. Events are stamped with the new
+// version and written to the outbox; Audit, when set, is appended to the
+// record's audit log. IfVersion, when set, makes the write conditional on the
+// record still being at that version, as read by GetWithVersion; the check and
+// the write are atomic and a mismatch fails with ErrVersionConflict.
+type WriteOptions struct {
+	Events    []ChangeEvent
+	Audit     *AuditInfo
+	IfVersion *int64
+}
+
+// ChangeEvent is a queue message describing a record change. The store sets
+// its "version" member to the record's new version when writing it.
+type ChangeEvent struct {
+	Queue   string
+	Message map[string]interface{}
+}
+
+// stampVersion serializes events with version set on each message.
+func stampVersion(events []ChangeEvent, version int64) ([]OutboxEvent, error) {
+	stamped := make([]OutboxEvent, 0, len(events))
+	for _, event := range events {
+		message := make(map[string]interface{}, len(event.Message)+1)
+		for key, value := range event.Message {
+			message[key] = value
+		}
+		message["version"] = version
+
+		messageJSON, err := json.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		stamped = append(stamped, OutboxEvent{Queue: event.Queue, Message: messageJSON})
+	}
+	return stamped, nil
+}
+
+// Outbox holds change events until the relay has published them. Events are
+// returned again until acknowledged, so delivery is at-least-once.
+type Outbox interface {
+	// ReadEvents returns up to count unacknowledged events, waiting up to
+	// block for new ones when none are pending.
+	ReadEvents(consumer string, count int, block time.Duration) ([]OutboxEvent, error)
+	AckEvents(ids ...string) error
+}
+
+// Checkpoints persists the progress of long-running jobs such as a rebuild so
+// they can resume where they stopped. GetCheckpoint returns "" when name has no
+// checkpoint.
+type Checkpoints interface {
+	GetCheckpoint(name string) (string, error)
+	SetCheckpoint(name, value string) error
+	DeleteCheckpoint(name string) error
+}
+
+// OutboxEvent is a serialized queue message waiting to be published. ID is
+// assigned by the outbox.
+type OutboxEvent struct {
+	ID      string
+	Queue   string
+	Message []byte
+}
+
+// RecordPage is one page of a List call. NextCursor is empty on the last page.
+type RecordPage struct {
+	Records    []map[string]interface{}
+	NextCursor string
+	Total      int64
+}
+
+func encodeCursor(raw string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	return string(raw), nil
+}
+
+// recordOrg returns the _org of a serialized record.
+func recordOrg(data []byte) string {
+	var record struct {
+		Org string `json:"_org"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return ""
+	}
+	return record.Org
+}
+
+func listsAllOrgs(orgs []string) bool {
+	return len(orgs) == 0 || containsOrg(orgs, config.AllOrgs)
+}
+
+func containsOrg(orgs []string, org string) bool {
+	for _, candidate := range orgs {
+		if candidate == org {
+			return true
+		}
+	}
+	return false
+}
@@ -2,31 +2,273 @@ package services
 
 import (
 	"context"
-	"csye7255-project-one/config"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-func CheckIfRecordExists(id string) (bool, error) {
-	exists, err := config.RedisClient.HExists(context.Background(), "plans", id).Result()
+const (
+	outboxGroup = "outbox-relay"
+
+	// Pending outbox entries idle for this long are claimed again, which
+	// retries events whose publish failed or whose relay died.
+	outboxClaimIdle = 30 * time.Second
+
+	maxWriteAttempts = 10
+)
+
+// RedisRecordStore keeps every record as a JSON field of a single Redis hash
+// and its version in a separate counter key. Its outbox is a Redis stream read
+// through a consumer group.
+type RedisRecordStore struct {
+	client    *redis.Client
+	key       string
+	outboxKey string
+	// groupReady is set once the consumer group exists. Relays may read
+	// concurrently, and a failed create is tried again on the next read.
+	groupReady atomic.Bool
+}
+
+func NewRedisRecordStore(client *redis.Client, key string) *RedisRecordStore {
+	return &RedisRecordStore{client: client, key: key, outboxKey: key + ":outbox"}
+}
+
+func (s *RedisRecordStore) Exists(id string) (bool, error) {
+	exists, err := s.client.HExists(context.Background(), s.key, id).Result()
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func SaveRecord(id string, data interface{}) error {
+func (s *RedisRecordStore) orgKey(org string) string {
+	return fmt.Sprintf("%s:org:%s", s.key, org)
+}
+
+// EnsureOrgIndex adds records written before org indices existed to the index
+// of their org, once per store.
+func (s *RedisRecordStore) EnsureOrgIndex() error {
+	ctx := context.Background()
+	readyKey := s.key + ":org-index-ready"
+
+	ready, err := s.client.Exists(ctx, readyKey).Result()
+	if err != nil || ready > 0 {
+		return err
+	}
+
+	var cursor uint64
+	for {
+		entries, next, err := s.client.HScan(ctx, s.key, cursor, "", 100).Result()
+		if err != nil {
+			return err
+		}
+		_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := 0; i+1 < len(entries); i += 2 {
+				if org := recordOrg([]byte(entries[i+1])); org != "" {
+					pipe.ZAdd(ctx, s.orgKey(org), redis.Z{Member: entries[i]})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	return s.client.Set(ctx, readyKey, "1", 0).Err()
+}
+
+func (s *RedisRecordStore) versionKey(id string) string {
+	return fmt.Sprintf("%s:version:%s", s.key, id)
+}
+
+func (s *RedisRecordStore) auditKey(id string) string {
+	return fmt.Sprintf("%s:audit:%s", s.key, id)
+}
+
+// historyKey holds the versions of id at their version number; historyTimeKey
+// holds the version numbers at their commit time in milliseconds. Those are
+// zero-padded so that among commits in the same millisecond, which share a
+// score and are ordered by member, the newest version sorts last.
+func (s *RedisRecordStore) historyKey(id string) string {
+	return fmt.Sprintf("%s:history:%s", s.key, id)
+}
+
+func (s *RedisRecordStore) historyTimeKey(id string) string {
+	return fmt.Sprintf("%s:history-at:%s", s.key, id)
+}
+
+func historyTimeMember(version int64) string {
+	return fmt.Sprintf("%020d", version)
+}
+
+// tombstoneKey holds the tombstone of a deleted id; deletedKey indexes the
+// tombstones by deletion time in milliseconds for the purge job.
+func (s *RedisRecordStore) tombstoneKey(id string) string {
+	return fmt.Sprintf("%s:deleted:%s", s.key, id)
+}
+
+func (s *RedisRecordStore) deletedKey() string {
+	return s.key + ":deleted"
+}
+
+func (s *RedisRecordStore) Save(id string, data interface{}, opts WriteOptions) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 
-	return config.RedisClient.HSet(context.Background(), "plans", id, jsonData).Err()
+	return s.write(id, opts, func(tx *redis.Tx, previous string) ([]byte, error) {
+		if previous != "" {
+			return jsonData, nil
+		}
+		tombstone, err := s.getTombstone(tx, id)
+		if err != nil {
+			return nil, err
+		} else if tombstone != nil && !tombstone.Expired() {
+			return nil, ErrRecordDeleted
+		}
+		return jsonData, nil
+	})
+}
+
+// write bumps the version of id and commits the record prepare returns, or
+// moves the current record to a tombstone when it returns nil, together with
+// the stamped events, audit entry and history entry in one MULTI/EXEC. prepare
+// runs inside the transaction with the current record ("" when absent) and may
+// reject the write. The version and tombstone keys are watched so concurrent
+// writers cannot hand out the same version or both pass an IfVersion check; a
+// lost race is retried and then sees the new version.
+func (s *RedisRecordStore) write(id string, opts WriteOptions, prepare func(tx *redis.Tx, previous string) ([]byte, error)) error {
+	ctx := context.Background()
+	versionKey := s.versionKey(id)
+	tombstoneKey := s.tombstoneKey(id)
+
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			current, err := tx.Get(ctx, versionKey).Int64()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if opts.IfVersion != nil && current != *opts.IfVersion {
+				return ErrVersionConflict
+			}
+			version := current + 1
+
+			// Every write bumps the watched version, so this read is consistent
+			previous, err := tx.HGet(ctx, s.key, id).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			previousOrg := recordOrg([]byte(previous))
+
+			record, err := prepare(tx, previous)
+			if err != nil {
+				return err
+			}
+			org := recordOrg(record)
+
+			stamped, err := stampVersion(opts.Events, version)
+			if err != nil {
+				return err
+			}
+
+			committedAt := time.Now().UTC()
+			planVersion, err := newPlanVersion(version, committedAt, []byte(previous), record)
+			if err != nil {
+				return err
+			}
+			versionJSON, err := json.Marshal(planVersion)
+			if err != nil {
+				return err
+			}
+
+			var entryJSON []byte
+			if opts.Audit != nil {
+				entry, err := newAuditEntry(id, opts.Audit, version, committedAt, []byte(previous), record)
+				if err != nil {
+					return err
+				}
+				if entryJSON, err = json.Marshal(entry); err != nil {
+					return err
+				}
+			}
+
+			var tombstoneJSON []byte
+			if record == nil && previous != "" {
+				tombstoneJSON, err = json.Marshal(Tombstone{
+					DeletedAt: committedAt,
+					Version:   version,
+					Org:       previousOrg,
+					Record:    json.RawMessage(previous),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if record != nil {
+					pipe.HSet(ctx, s.key, id, record)
+					pipe.Del(ctx, tombstoneKey)
+					pipe.ZRem(ctx, s.deletedKey(), id)
+				} else {
+					pipe.HDel(ctx, s.key, id)
+				}
+				if tombstoneJSON != nil {
+					pipe.Set(ctx, tombstoneKey, tombstoneJSON, 0)
+					pipe.ZAdd(ctx, s.deletedKey(), redis.Z{Score: float64(committedAt.UnixMilli()), Member: id})
+				}
+				if previousOrg != "" && previousOrg != org {
+					pipe.ZRem(ctx, s.orgKey(previousOrg), id)
+				}
+				if org != "" {
+					pipe.ZAdd(ctx, s.orgKey(org), redis.Z{Member: id})
+				}
+				pipe.Set(ctx, versionKey, version, 0)
+				s.addEvents(pipe, stamped)
+				if entryJSON != nil {
+					pipe.XAdd(ctx, &redis.XAddArgs{
+						Stream: s.auditKey(id),
+						Values: map[string]interface{}{"entry": entryJSON},
+					})
+				}
+				pipe.ZAdd(ctx, s.historyKey(id), redis.Z{Score: float64(version), Member: versionJSON})
+				pipe.ZAdd(ctx, s.historyTimeKey(id), redis.Z{Score: float64(committedAt.UnixMilli()), Member: historyTimeMember(version)})
+				return nil
+			})
+			return err
+		}, versionKey, tombstoneKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("gave up writing %s after %d conflicting attempts", id, maxWriteAttempts)
+}
+
+func (s *RedisRecordStore) addEvents(pipe redis.Pipeliner, events []OutboxEvent) {
+	for _, event := range events {
+		pipe.XAdd(context.Background(), &redis.XAddArgs{
+			Stream: s.outboxKey,
+			Values: map[string]interface{}{
+				"queue":   event.Queue,
+				"message": event.Message,
+			},
+		})
+	}
 }
 
-func GetRecord(id string) (map[string]interface{}, error) {
-	result, err := config.RedisClient.HGet(context.Background(), "plans", id).Result()
+func (s *RedisRecordStore) Get(id string) (map[string]interface{}, error) {
+	result, err := s.client.HGet(context.Background(), s.key, id).Result()
 	if err == redis.Nil {
 		return nil, nil
 	} else if err != nil {
@@ -41,8 +283,40 @@ func GetRecord(id string) (map[string]interface{}, error) {
 	return record, nil
 }
 
-func GetAllRecords() ([]map[string]interface{}, error) {
-	results, err := config.RedisClient.HGetAll(context.Background(), "plans").Result()
+func (s *RedisRecordStore) GetWithVersion(id string) (map[string]interface{}, int64, error) {
+	ctx := context.Background()
+
+	var recordCmd, versionCmd *redis.StringCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		recordCmd = pipe.HGet(ctx, s.key, id)
+		versionCmd = pipe.Get(ctx, s.versionKey(id))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+
+	version, err := versionCmd.Int64()
+	if err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+
+	result, err := recordCmd.Result()
+	if err == redis.Nil {
+		return nil, version, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(result), &record); err != nil {
+		return nil, 0, err
+	}
+	return record, version, nil
+}
+
+func (s *RedisRecordStore) GetAll() ([]map[string]interface{}, error) {
+	results, err := s.client.HGetAll(context.Background(), s.key).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +332,439 @@ func GetAllRecords() ([]map[string]interface{}, error) {
 	return records, nil
 }
 
-func DeleteRecord(id string) error {
-	return config.RedisClient.HDel(context.Background(), "plans", id).Err()
+func (s *RedisRecordStore) List(cursor string, limit int, orgs ...string) (*RecordPage, error) {
+	if listsAllOrgs(orgs) {
+		return s.listAll(cursor, limit)
+	}
+	return s.listOrgs(cursor, limit, orgs)
+}
+
+// listAll walks the hash with HSCAN. The cursor carries the HSCAN cursor plus
+// the number of entries of that batch already returned, since HSCAN may hand
+// back more entries than requested (small hashes come back in a single batch).
+func (s *RedisRecordStore) listAll(cursor string, limit int) (*RecordPage, error) {
+	var scanCursor uint64
+	var skip int
+	if cursor != "" {
+		raw, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fmt.Sscanf(raw, "%d:%d", &scanCursor, &skip); err != nil || skip < 0 {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	ctx := context.Background()
+	total, err := s.client.HLen(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &RecordPage{Records: []map[string]interface{}{}, Total: total}
+	for {
+		entries, next, err := s.client.HScan(ctx, s.key, scanCursor, "", int64(limit)).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		// entries alternates field and value
+		values := make([]string, 0, len(entries)/2)
+		for i := 1; i < len(entries); i += 2 {
+			values = append(values, entries[i])
+		}
+		if skip < len(values) {
+			values = values[skip:]
+		} else {
+			values = nil
+		}
+
+		room := limit - len(page.Records)
+		if len(values) > room {
+			appendRecords(page, values[:room])
+			page.NextCursor = encodeCursor(fmt.Sprintf("%d:%d", scanCursor, skip+room))
+			return page, nil
+		}
+		appendRecords(page, values)
+
+		skip = 0
+		scanCursor = next
+		if scanCursor == 0 {
+			return page, nil
+		}
+		if len(page.Records) == limit {
+			page.NextCursor = encodeCursor(fmt.Sprintf("%d:0", scanCursor))
+			return page, nil
+		}
+	}
+}
+
+// listOrgs merges the org indices, sorted sets holding the ids of each org at
+// score 0 so they page by id with ZRANGEBYLEX. The cursor is the last id
+// returned.
+func (s *RedisRecordStore) listOrgs(cursor string, limit int, orgs []string) (*RecordPage, error) {
+	min := "-"
+	if cursor != "" {
+		raw, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		min = "(" + raw
+	}
+
+	ctx := context.Background()
+	page := &RecordPage{Records: []map[string]interface{}{}}
+	seen := map[string]bool{}
+	var ids []string
+	for _, org := range orgs {
+		count, err := s.client.ZCard(ctx, s.orgKey(org)).Result()
+		if err != nil {
+			return nil, err
+		}
+		page.Total += count
+
+		orgIDs, err := s.client.ZRangeByLex(ctx, s.orgKey(org), &redis.ZRangeBy{Min: min, Max: "+", Count: int64(limit) + 1}).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range orgIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	hasMore := len(ids) > limit
+	if hasMore {
+		ids = ids[:limit]
+		page.NextCursor = encodeCursor(ids[len(ids)-1])
+	}
+	if len(ids) == 0 {
+		return page, nil
+	}
+
+	values, err := s.client.HMGet(ctx, s.key, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		jsonString, ok := value.(string)
+		// Skip ids deleted meanwhile and entries left behind by a backfill
+		if !ok || !containsOrg(orgs, recordOrg([]byte(jsonString))) {
+			continue
+		}
+		appendRecords(page, []string{jsonString})
+	}
+	return page, nil
+}
+
+func appendRecords(page *RecordPage, values []string) {
+	for _, jsonString := range values {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(jsonString), &record); err != nil {
+			continue
+		}
+		page.Records = append(page.Records, record)
+	}
+}
+
+func (s *RedisRecordStore) Delete(id string, opts WriteOptions) error {
+	return s.write(id, opts, func(tx *redis.Tx, previous string) ([]byte, error) {
+		return nil, nil
+	})
+}
+
+func (s *RedisRecordStore) GetDeleted(id string) (*Tombstone, error) {
+	return s.getTombstone(s.client, id)
+}
+
+func (s *RedisRecordStore) getTombstone(client redis.Cmdable, id string) (*Tombstone, error) {
+	result, err := client.Get(context.Background(), s.tombstoneKey(id)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var tombstone Tombstone
+	if err := json.Unmarshal([]byte(result), &tombstone); err != nil {
+		return nil, err
+	}
+	return &tombstone, nil
+}
+
+func (s *RedisRecordStore) Restore(id string, deletedVersion int64, opts WriteOptions) error {
+	return s.write(id, opts, func(tx *redis.Tx, previous string) ([]byte, error) {
+		if previous != "" {
+			return nil, ErrRecordExists
+		}
+		tombstone, err := s.getTombstone(tx, id)
+		if err != nil {
+			return nil, err
+		} else if tombstone == nil || tombstone.Version != deletedVersion {
+			return nil, ErrNotDeleted
+		}
+		return tombstone.Record, nil
+	})
+}
+
+// PurgeDeleted removes each expired tombstone in its own transaction watching
+// the tombstone, so a concurrent restore or delete wins and the tombstone is
+// left for the next run.
+func (s *RedisRecordStore) PurgeDeleted(before time.Time) (int, error) {
+	ctx := context.Background()
+	ids, err := s.client.ZRangeByScore(ctx, s.deletedKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(before.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		expired := false
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			tombstone, err := s.getTombstone(tx, id)
+			if err != nil {
+				return err
+			}
+			if tombstone != nil && !tombstone.DeletedAt.Before(before) {
+				return nil
+			}
+			expired = tombstone != nil
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.ZRem(ctx, s.deletedKey(), id)
+				if expired {
+					pipe.Del(ctx, s.tombstoneKey(id), s.historyKey(id), s.historyTimeKey(id))
+				}
+				return nil
+			})
+			return err
+		}, s.tombstoneKey(id))
+		if err == redis.TxFailedErr {
+			continue
+		} else if err != nil {
+			return purged, err
+		}
+		if expired {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// AuditLog reads the audit stream of id with XRANGE; the cursor is the last
+// stream id returned.
+func (s *RedisRecordStore) AuditLog(id string, cursor string, limit int) (*AuditPage, error) {
+	start := "-"
+	if cursor != "" {
+		raw, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		start = "(" + raw
+	}
+
+	messages, err := s.client.XRangeN(context.Background(), s.auditKey(id), start, "+", int64(limit)+1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &AuditPage{Entries: []AuditEntry{}}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		page.NextCursor = encodeCursor(messages[len(messages)-1].ID)
+	}
+	for _, message := range messages {
+		entryJSON, _ := message.Values["entry"].(string)
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+			continue
+		}
+		entry.ID = message.ID
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, nil
+}
+
+// Versions pages by version number; the cursor is the last version returned.
+func (s *RedisRecordStore) Versions(id string, cursor string, limit int) (*VersionPage, error) {
+	min := "-inf"
+	if cursor != "" {
+		raw, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+		min = "(" + raw
+	}
+
+	members, err := s.client.ZRangeByScore(context.Background(), s.historyKey(id), &redis.ZRangeBy{
+		Min:   min,
+		Max:   "+inf",
+		Count: int64(limit) + 1,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &VersionPage{Versions: []PlanVersion{}}
+	hasMore := len(members) > limit
+	if hasMore {
+		members = members[:limit]
+	}
+	for _, member := range members {
+		version, err := versionSummary([]byte(member))
+		if err != nil {
+			continue
+		}
+		page.Versions = append(page.Versions, version)
+	}
+	if hasMore && len(page.Versions) > 0 {
+		page.NextCursor = encodeCursor(strconv.FormatInt(page.Versions[len(page.Versions)-1].Version, 10))
+	}
+	return page, nil
+}
+
+func (s *RedisRecordStore) GetVersion(id string, n int64) (*PlanVersion, error) {
+	score := strconv.FormatInt(n, 10)
+	members, err := s.client.ZRangeByScore(context.Background(), s.historyKey(id), &redis.ZRangeBy{Min: score, Max: score}).Result()
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+
+	var version PlanVersion
+	if err := json.Unmarshal([]byte(members[0]), &version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+func (s *RedisRecordStore) GetVersionAsOf(id string, t time.Time) (*PlanVersion, error) {
+	members, err := s.client.ZRevRangeByScore(context.Background(), s.historyTimeKey(id), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(t.UnixMilli(), 10),
+		Count: 1,
+	}).Result()
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+
+	n, err := strconv.ParseInt(members[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetVersion(id, n)
+}
+
+// ReadEvents first returns the entries still pending for consumer, such as
+// those whose publish failed, so a failed event is retried before anything
+// newer goes out. It then reclaims entries other consumers left pending for
+// outboxClaimIdle, and only then reads new entries.
+func (s *RedisRecordStore) ReadEvents(consumer string, count int, block time.Duration) ([]OutboxEvent, error) {
+	ctx := context.Background()
+	if !s.groupReady.Load() {
+		err := s.client.XGroupCreateMkStream(ctx, s.outboxKey, outboxGroup, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, err
+		}
+		s.groupReady.Store(true)
+	}
+
+	pending, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    outboxGroup,
+		Consumer: consumer,
+		Streams:  []string{s.outboxKey, "0"},
+		Count:    int64(count),
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for _, stream := range pending {
+		if len(stream.Messages) > 0 {
+			return outboxEvents(stream.Messages), nil
+		}
+	}
+
+	claimed, _, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   s.outboxKey,
+		Group:    outboxGroup,
+		MinIdle:  outboxClaimIdle,
+		Start:    "0-0",
+		Count:    int64(count),
+		Consumer: consumer,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(claimed) > 0 {
+		return outboxEvents(claimed), nil
+	}
+
+	streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    outboxGroup,
+		Consumer: consumer,
+		Streams:  []string{s.outboxKey, ">"},
+		Count:    int64(count),
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var events []OutboxEvent
+	for _, stream := range streams {
+		events = append(events, outboxEvents(stream.Messages)...)
+	}
+	return events, nil
+}
+
+func outboxEvents(messages []redis.XMessage) []OutboxEvent {
+	events := make([]OutboxEvent, 0, len(messages))
+	for _, message := range messages {
+		queue, _ := message.Values["queue"].(string)
+		body, _ := message.Values["message"].(string)
+		events = append(events, OutboxEvent{ID: message.ID, Queue: queue, Message: []byte(body)})
+	}
+	return events
+}
+
+func (s *RedisRecordStore) AckEvents(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.XAck(context.Background(), s.outboxKey, outboxGroup, ids...)
+		pipe.XDel(context.Background(), s.outboxKey, ids...)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisRecordStore) checkpointKey(name string) string {
+	return fmt.Sprintf("%s:checkpoint:%s", s.key, name)
+}
+
+func (s *RedisRecordStore) GetCheckpoint(name string) (string, error) {
+	value, err := s.client.Get(context.Background(), s.checkpointKey(name)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *RedisRecordStore) SetCheckpoint(name, value string) error {
+	return s.client.Set(context.Background(), s.checkpointKey(name), value, 0).Err()
+}
+
+func (s *RedisRecordStore) DeleteCheckpoint(name string) error {
+	return s.client.Del(context.Background(), s.checkpointKey(name)).Err()
 }
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"csye7255-project-one/models"
+)
+
+var ErrReindexRunning = errors.New("a reindex is already running")
+
+type ReindexReport struct {
+	Alias         string   `json:"alias"`
+	Sources       []string `json:"sources"`
+	Target        string   `json:"target"`
+	PlansIndexed  int      `json:"plansIndexed"`
+	PlansRemoved  int      `json:"plansRemoved"`
+	SourceDeleted bool     `json:"sourceDeleted"`
+}
+
+// Reindex builds a new physical index for alias from the records in store and
+// atomically moves alias onto it. While it runs, consumers write every change
+// to the new index as well, and external versioning keeps whichever of the
+// load and a live change is newer. A concrete index named alias is replaced by
+// the alias in the same atomic step. With deleteOld, the previous physical
+// indices are deleted afterwards.
+func Reindex(store RecordStore, alias string, deleteOld bool) (report *ReindexReport, err error) {
+	report = &ReindexReport{Alias: alias}
+
+	running, err := resolveAlias(reindexAlias(alias))
+	if err != nil {
+		return report, err
+	}
+	if len(running) > 0 {
+		return report, fmt.Errorf("%w: building %s", ErrReindexRunning, running[0])
+	}
+
+	sources, err := resolveAlias(alias)
+	if err != nil {
+		return report, err
+	}
+	legacy := false
+	if len(sources) == 0 {
+		exists, err := indexExists(alias)
+		if err != nil {
+			return report, err
+		}
+		if exists {
+			legacy = true
+			sources = []string{alias}
+		}
+	}
+	report.Sources = sources
+
+	existing, err := listPhysicalIndices(alias)
+	if err != nil {
+		return report, err
+	}
+	target := physicalIndexName(alias, nextIndexVersion(alias, existing))
+	report.Target = target
+
+	// Creating the target with the reindex alias announces it to consumers
+	if err := createIndex(target, map[string]interface{}{reindexAlias(alias): map[string]interface{}{}}); err != nil {
+		return report, err
+	}
+	defer func() {
+		if err != nil {
+			log.Printf("Reindex into %s failed, deleting it: %v", target, err)
+			// Let consumers stop writing to it first, or they would recreate it
+			removeErr := updateAliases([]map[string]interface{}{
+				{"remove": map[string]interface{}{"index": target, "alias": reindexAlias(alias)}},
+			})
+			if removeErr == nil {
+				time.Sleep(reindexTargetTTL)
+			}
+			if deleteErr := deleteIndex(target); deleteErr != nil {
+				log.Printf("Failed to delete %s: %v", target, deleteErr)
+			}
+		}
+	}()
+	log.Printf("Reindexing %s into %s", alias, target)
+	time.Sleep(reindexTargetTTL)
+
+	if err := loadIndex(store, target, func(indexed int) {
+		report.PlansIndexed = indexed
+		log.Printf("Reindexed %d plans into %s", indexed, target)
+	}); err != nil {
+		return report, err
+	}
+
+	// Plans deleted during the load may have been re-added by it
+	orphans := &ReconcileReport{}
+	if err := reconcileOrphanedPlans(store, target, true, orphans); err != nil {
+		return report, err
+	}
+	report.PlansRemoved = orphans.PlansRepaired
+
+	actions := []map[string]interface{}{}
+	if legacy {
+		actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": alias}})
+	} else {
+		for _, source := range sources {
+			actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": source, "alias": alias}})
+		}
+	}
+	actions = append(actions,
+		map[string]interface{}{"add": map[string]interface{}{"index": target, "alias": alias, "is_write_index": true}},
+		map[string]interface{}{"remove": map[string]interface{}{"index": target, "alias": reindexAlias(alias)}},
+	)
+	if err := updateAliases(actions); err != nil {
+		return report, err
+	}
+	log.Printf("Alias %s now points to %s", alias, target)
+
+	if deleteOld && !legacy {
+		for _, source := range sources {
+			if err := deleteIndex(source); err != nil {
+				log.Printf("Failed to delete previous index %s: %v", source, err)
+				return report, nil
+			}
+		}
+		report.SourceDeleted = len(sources) > 0
+	}
+	return report, nil
+}
+
+// loadIndex indexes every record of store into index, one bulk request per
+// page, each plan at its current version. progress is called after each page.
+func loadIndex(store RecordStore, index string, progress func(indexed int)) error {
+	indexed := 0
+	cursor := ""
+	for {
+		page, err := store.List(cursor, reconcilePageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list records: %v", err)
+		}
+
+		var actions []bulkAction
+		for _, listed := range page.Records {
+			id, _ := listed["objectId"].(string)
+			record, version, err := store.GetWithVersion(id)
+			if err != nil {
+				return fmt.Errorf("failed to fetch record %s: %v", id, err)
+			}
+			if record == nil {
+				continue
+			}
+
+			var plan models.Plan
+			if err := mapToStruct(record, &plan); err != nil {
+				return fmt.Errorf("failed to parse record %s: %v", id, err)
+			}
+			actions = append(actions, indexActions(planDocuments(plan), version)...)
+			indexed++
+		}
+		if err := bulk(index, actions); err != nil {
+			return err
+		}
+		progress(indexed)
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"csye7255-project-one/config"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+const maxSearchSize = 100
+
+var (
+	ErrInvalidSearchQuery = errors.New("invalid search query")
+
+	ignoreUnavailable = true
+	allowNoIndices    = true
+)
+
+// SearchQuery is the constrained query language accepted by the search API.
+// Type names the relation the clause applies to (defaults to "plan");
+// Filters are exact matches, Ranges apply to numeric and date fields, and
+// HasChild/HasParent nest clauses over the join relation. Clauses on child
+// relations are lifted to the owning plan, so a search always yields plans.
+type SearchQuery struct {
+	Type      string                 `json:"type"`
+	Filters   map[string]interface{} `json:"filters"`
+	Ranges    map[string]RangeFilter `json:"ranges"`
+	HasChild  []SearchQuery          `json:"hasChild"`
+	HasParent *SearchQuery           `json:"hasParent"`
+	From      int                    `json:"from"`
+	Size      int                    `json:"size"`
+	// Orgs restricts the matched plans to those of the caller's orgs.
+	Orgs []string `json:"-"`
+}
+
+// RangeFilter bounds are numbers for numeric fields and MM-DD-YYYY strings
+// for date fields.
+type RangeFilter struct {
+	Gt  interface{} `json:"gt,omitempty"`
+	Gte interface{} `json:"gte,omitempty"`
+	Lt  interface{} `json:"lt,omitempty"`
+	Lte interface{} `json:"lte,omitempty"`
+}
+
+type SearchResult struct {
+	Total   int64
+	PlanIDs []string
+}
+
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	keywordField
+	numericField
+	dateField
+)
+
+var commonSearchFields = map[string]fieldKind{
+	"_org":       keywordField,
+	"objectId":   keywordField,
+	"objectType": keywordField,
+}
+
+// searchFields lists the queryable fields of each relation in the join index.
+var searchFields = map[string]map[string]fieldKind{
+	"plan": withCommonFields(map[string]fieldKind{
+		"planType":     keywordField,
+		"creationDate": dateField,
+	}),
+	"planCostShares": withCommonFields(map[string]fieldKind{
+		"copay":      numericField,
+		"deductible": numericField,
+	}),
+	"linkedPlanServices": withCommonFields(nil),
+	"linkedService": withCommonFields(map[string]fieldKind{
+		"name": textField,
+	}),
+	"planServiceCostShares": withCommonFields(map[string]fieldKind{
+		"copay":      numericField,
+		"deductible": numericField,
+	}),
+}
+
+// relationParents mirrors the join mapping in planIndexMappings.
+var relationParents = map[string]string{
+	"planCostShares":        "plan",
+	"linkedPlanServices":    "plan",
+	"linkedService":         "linkedPlanServices",
+	"planServiceCostShares": "linkedPlanServices",
+}
+
+func withCommonFields(fields map[string]fieldKind) map[string]fieldKind {
+	merged := make(map[string]fieldKind, len(commonSearchFields)+len(fields))
+	for name, kind := range commonSearchFields {
+		merged[name] = kind
+	}
+	for name, kind := range fields {
+		merged[name] = kind
+	}
+	return merged
+}
+
+// BuildSearchQuery validates q and translates it to an Elasticsearch query
+// that matches plan documents.
+func BuildSearchQuery(q SearchQuery) (map[string]interface{}, error) {
+	if q.Type == "" {
+		q.Type = "plan"
+	}
+
+	clause, err := buildClause(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lift clauses on child relations up to the plan that owns them
+	for relation := q.Type; relation != "plan"; relation = relationParents[relation] {
+		clause = map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					relationTerm(relationParents[relation]),
+					hasChild(relation, clause),
+				},
+			},
+		}
+	}
+
+	return clause, nil
+}
+
+func hasChild(relation string, query map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"has_child": map[string]interface{}{
+			"type":  relation,
+			"query": query,
+		},
+	}
+}
+
+func relationTerm(relation string) map[string]interface{} {
+	return map[string]interface{}{
+		"term": map[string]interface{}{"relation": relation},
+	}
+}
+
+func buildClause(q SearchQuery) (map[string]interface{}, error) {
+	fields, ok := searchFields[q.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown type: %s", ErrInvalidSearchQuery, q.Type)
+	}
+
+	filters := []interface{}{relationTerm(q.Type)}
+
+	for field, value := range q.Filters {
+		kind, ok := fields[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: field %s cannot be filtered on %s", ErrInvalidSearchQuery, field, q.Type)
+		}
+		switch kind {
+		case keywordField:
+			filters = append(filters, map[string]interface{}{
+				"term": map[string]interface{}{field + ".keyword": value},
+			})
+		case numericField:
+			filters = append(filters, map[string]interface{}{
+				"term": map[string]interface{}{field: value},
+			})
+		case dateField:
+			if !validDateBound(value) {
+				return nil, fmt.Errorf("%w: %s must be a date in MM-DD-YYYY format", ErrInvalidSearchQuery, field)
+			}
+			filters = append(filters, map[string]interface{}{
+				"term": map[string]interface{}{field: value},
+			})
+		default:
+			filters = append(filters, map[string]interface{}{
+				"match_phrase": map[string]interface{}{field: value},
+			})
+		}
+	}
+
+	for field, r := range q.Ranges {
+		kind, ok := fields[field]
+		if !ok || (kind != numericField && kind != dateField) {
+			return nil, fmt.Errorf("%w: field %s does not support ranges on %s", ErrInvalidSearchQuery, field, q.Type)
+		}
+		if r.Gt == nil && r.Gte == nil && r.Lt == nil && r.Lte == nil {
+			return nil, fmt.Errorf("%w: range on %s needs at least one bound", ErrInvalidSearchQuery, field)
+		}
+		for _, bound := range []interface{}{r.Gt, r.Gte, r.Lt, r.Lte} {
+			if bound == nil {
+				continue
+			}
+			if kind == numericField {
+				if _, ok := bound.(float64); !ok {
+					return nil, fmt.Errorf("%w: range on %s needs numeric bounds", ErrInvalidSearchQuery, field)
+				}
+			} else if !validDateBound(bound) {
+				return nil, fmt.Errorf("%w: range on %s needs MM-DD-YYYY bounds", ErrInvalidSearchQuery, field)
+			}
+		}
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{field: r},
+		})
+	}
+
+	for _, child := range q.HasChild {
+		if relationParents[child.Type] != q.Type {
+			return nil, fmt.Errorf("%w: %s is not a child of %s", ErrInvalidSearchQuery, child.Type, q.Type)
+		}
+		childClause, err := buildClause(child)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, hasChild(child.Type, childClause))
+	}
+
+	if q.HasParent != nil {
+		if relationParents[q.Type] != q.HasParent.Type || q.HasParent.Type == "" {
+			return nil, fmt.Errorf("%w: %s is not the parent of %s", ErrInvalidSearchQuery, q.HasParent.Type, q.Type)
+		}
+		parentClause, err := buildClause(*q.HasParent)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, map[string]interface{}{
+			"has_parent": map[string]interface{}{
+				"parent_type": q.HasParent.Type,
+				"query":       parentClause,
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{"filter": filters},
+	}, nil
+}
+
+func validDateBound(value interface{}) bool {
+	date, ok := value.(string)
+	if !ok {
+		return false
+	}
+	_, err := time.Parse("01-02-2006", date)
+	return err == nil
+}
+
+// SearchPlans runs q against the join index and returns the matching plan IDs.
+func SearchPlans(index string, q SearchQuery) (*SearchResult, error) {
+	if config.ESClient == nil {
+		return nil, errors.New("elasticsearch client is not initialized")
+	}
+	if q.From < 0 || q.Size < 0 || q.Size > maxSearchSize {
+		return nil, fmt.Errorf("%w: size must be between 0 and %d and from must not be negative", ErrInvalidSearchQuery, maxSearchSize)
+	}
+	size := q.Size
+	if size == 0 {
+		size = 20
+	}
+
+	query, err := BuildSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	if orgFilter := orgTermsFilter(q.Orgs); orgFilter != nil {
+		query = map[string]interface{}{
+			"bool": map[string]interface{}{"filter": []interface{}{query, orgFilter}},
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query":   query,
+		"from":    q.From,
+		"size":    size,
+		"_source": false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal search query: %v", err)
+	}
+
+	// Per-org indices are only created once the org has plans
+	req := esapi.SearchRequest{
+		Index:             []string{index},
+		Body:              bytes.NewReader(body),
+		IgnoreUnavailable: &ignoreUnavailable,
+		AllowNoIndices:    &allowNoIndices,
+	}
+	res, err := req.Do(context.Background(), config.ESClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search plans: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("failed to search plans: %s", res.Status())
+	}
+
+	var searchResults struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&searchResults); err != nil {
+		return nil, fmt.Errorf("failed to parse search results: %v", err)
+	}
+
+	result := &SearchResult{Total: searchResults.Hits.Total.Value, PlanIDs: []string{}}
+	for _, hit := range searchResults.Hits.Hits {
+		result.PlanIDs = append(result.PlanIDs, hit.ID)
+	}
+	return result, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBuildSearchQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "defaults to plan",
+			query: `{}`,
+			want:  `{"bool":{"filter":[{"term":{"relation":"plan"}}]}}`,
+		},
+		{
+			name:  "keyword filter",
+			query: `{"filters":{"planType":"inNetwork"}}`,
+			want:  `{"bool":{"filter":[{"term":{"relation":"plan"}},{"term":{"planType.keyword":"inNetwork"}}]}}`,
+		},
+		{
+			name:  "date filter",
+			query: `{"filters":{"creationDate":"12-12-2017"}}`,
+			want:  `{"bool":{"filter":[{"term":{"relation":"plan"}},{"term":{"creationDate":"12-12-2017"}}]}}`,
+		},
+		{
+			name:  "text filter",
+			query: `{"type":"linkedService","filters":{"name":"Yearly physical"}}`,
+			want: `{"bool":{"filter":[{"term":{"relation":"plan"}},{"has_child":{"type":"linkedPlanServices","query":` +
+				`{"bool":{"filter":[{"term":{"relation":"linkedPlanServices"}},{"has_child":{"type":"linkedService","query":` +
+				`{"bool":{"filter":[{"term":{"relation":"linkedService"}},{"match_phrase":{"name":"Yearly physical"}}]}}}}]}}}}]}}`,
+		},
+		{
+			name:  "numeric range",
+			query: `{"type":"planCostShares","ranges":{"copay":{"gte":10,"lt":50}}}`,
+			want: `{"bool":{"filter":[{"term":{"relation":"plan"}},{"has_child":{"type":"planCostShares","query":` +
+				`{"bool":{"filter":[{"term":{"relation":"planCostShares"}},{"range":{"copay":{"gte":10,"lt":50}}}]}}}}]}}`,
+		},
+		{
+			name:  "has child",
+			query: `{"hasChild":[{"type":"planCostShares","filters":{"deductible":2000}}]}`,
+			want: `{"bool":{"filter":[{"term":{"relation":"plan"}},{"has_child":{"type":"planCostShares","query":` +
+				`{"bool":{"filter":[{"term":{"relation":"planCostShares"}},{"term":{"deductible":2000}}]}}}}]}}`,
+		},
+		{
+			name:  "has parent",
+			query: `{"type":"planCostShares","hasParent":{"type":"plan","filters":{"_org":"example.com"}}}`,
+			want: `{"bool":{"filter":[{"term":{"relation":"plan"}},{"has_child":{"type":"planCostShares","query":` +
+				`{"bool":{"filter":[{"term":{"relation":"planCostShares"}},{"has_parent":{"parent_type":"plan","query":` +
+				`{"bool":{"filter":[{"term":{"relation":"plan"}},{"term":{"_org.keyword":"example.com"}}]}}}}]}}}}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var q SearchQuery
+			if err := json.Unmarshal([]byte(tt.query), &q); err != nil {
+				t.Fatalf("invalid query: %v", err)
+			}
+
+			got, err := BuildSearchQuery(q)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Compare decoded JSON so member order does not matter
+			gotJSON, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("failed to marshal query: %v", err)
+			}
+			var gotValue, wantValue interface{}
+			json.Unmarshal(gotJSON, &gotValue)
+			json.Unmarshal([]byte(tt.want), &wantValue)
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("got  %s\nwant %s", gotJSON, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSearchQueryRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"unknown type", `{"type":"member"}`},
+		{"unknown field", `{"filters":{"copay":10}}`},
+		{"malformed date filter", `{"filters":{"creationDate":"2017-12-12"}}`},
+		{"range on keyword field", `{"ranges":{"planType":{"gt":1}}}`},
+		{"range without bounds", `{"type":"planCostShares","ranges":{"copay":{}}}`},
+		{"non-numeric bound", `{"type":"planCostShares","ranges":{"copay":{"gt":"10"}}}`},
+		{"malformed date bound", `{"ranges":{"creationDate":{"gt":"yesterday"}}}`},
+		{"child of wrong relation", `{"hasChild":[{"type":"linkedService"}]}`},
+		{"parent of wrong relation", `{"type":"linkedService","hasParent":{"type":"plan"}}`},
+		{"parent without type", `{"type":"planCostShares","hasParent":{}}`},
+		{"invalid nested clause", `{"hasChild":[{"type":"planCostShares","filters":{"name":"x"}}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var q SearchQuery
+			if err := json.Unmarshal([]byte(tt.query), &q); err != nil {
+				t.Fatalf("invalid query: %v", err)
+			}
+
+			if _, err := BuildSearchQuery(q); !errors.Is(err, ErrInvalidSearchQuery) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidSearchQuery)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"csye7255-project-one/config"
+	"csye7255-project-one/models"
+)
+
+var ensuredIndices sync.Map
+
+// OrgAllowed reports whether a caller bound to orgs may access plans of org.
+func OrgAllowed(orgs []string, org string) bool {
+	return containsOrg(orgs, config.AllOrgs) || containsOrg(orgs, org)
+}
+
+// PlanOrgMismatch returns the path of the first child of plan whose _org
+// differs from the plan's, or "" when all of them match.
+func PlanOrgMismatch(plan models.Plan) string {
+	if plan.PlanCostShares.Org != plan.Org {
+		return "planCostShares"
+	}
+	for i, service := range plan.LinkedPlanServices {
+		if service.Org != plan.Org {
+			return fmt.Sprintf("linkedPlanServices[%d]", i)
+		}
+		if service.LinkedService.Org != plan.Org {
+			return fmt.Sprintf("linkedPlanServices[%d].linkedService", i)
+		}
+		if service.PlanServiceCostShares.Org != plan.Org {
+			return fmt.Sprintf("linkedPlanServices[%d].planserviceCostShares", i)
+		}
+	}
+	return ""
+}
+
+// orgIndicesEnabled reports whether ORG_INDICES=true routes every org to its
+// own index instead of the shared one.
+func orgIndicesEnabled() bool {
+	return os.Getenv("ORG_INDICES") == "true"
+}
+
+// IndexForOrg returns the index the plans of org are synced to. With
+// per-org indices each org gets a concrete index <base>_org_<org>, created on
+// first use; those indices are not managed by the reindex command.
+func IndexForOrg(base, org string) string {
+	if !orgIndicesEnabled() {
+		return base
+	}
+	return base + "_org_" + sanitizeIndexName(org)
+}
+
+// SearchIndex returns the index expression covering the plans of orgs.
+func SearchIndex(base string, orgs []string) string {
+	if !orgIndicesEnabled() {
+		return base
+	}
+	if containsOrg(orgs, config.AllOrgs) {
+		return base + "_org_*"
+	}
+	indices := make([]string, 0, len(orgs))
+	for _, org := range orgs {
+		indices = append(indices, IndexForOrg(base, org))
+	}
+	return strings.Join(indices, ",")
+}
+
+// sanitizeIndexName lower-cases org and replaces characters Elasticsearch
+// does not allow in index names.
+func sanitizeIndexName(org string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '-'
+		}
+	}, org)
+}
+
+// ensureIndex creates index with the plan mapping unless it or an alias of
+// that name exists. Checked indices are remembered for the process lifetime.
+func ensureIndex(index string) error {
+	if _, ok := ensuredIndices.Load(index); ok {
+		return nil
+	}
+
+	exists, err := indexExists(index)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := createIndex(index, nil); err != nil {
+			return err
+		}
+	}
+	ensuredIndices.Store(index, true)
+	return nil
+}
+
+// orgTermsFilter restricts a query over plans to orgs, or returns nil when
+// orgs covers every org.
+func orgTermsFilter(orgs []string) map[string]interface{} {
+	if containsOrg(orgs, config.AllOrgs) {
+		return nil
+	}
+	return map[string]interface{}{
+		"terms": map[string]interface{}{"_org.keyword": orgs},
+	}
+}
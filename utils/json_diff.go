@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffOperation is one difference between two JSON documents, shaped like an
+// RFC 6902 operation that also carries the value it replaced or removed.
+type DiffOperation struct {
+	Op       string      `json:"op"`
+	Path     string      `json:"path"`
+	Value    interface{} `json:"value,omitempty"`
+	Previous interface{} `json:"previous,omitempty"`
+}
+
+// Diff returns the operations that turn before into after. Both must be
+// decoded JSON (maps, slices and scalars); either may be nil for a document
+// that does not exist. Object members are visited in key order and array
+// elements by index, so the result is stable.
+func Diff(before, after interface{}) []DiffOperation {
+	ops := []DiffOperation{}
+	diffValues("", before, after, &ops)
+	return ops
+}
+
+func diffValues(path string, before, after interface{}, ops *[]DiffOperation) {
+	beforeObject, beforeIsObject := before.(map[string]interface{})
+	afterObject, afterIsObject := after.(map[string]interface{})
+	if beforeIsObject && afterIsObject {
+		diffObjects(path, beforeObject, afterObject, ops)
+		return
+	}
+
+	beforeArray, beforeIsArray := before.([]interface{})
+	afterArray, afterIsArray := after.([]interface{})
+	if beforeIsArray && afterIsArray {
+		diffArrays(path, beforeArray, afterArray, ops)
+		return
+	}
+
+	switch {
+	case reflect.DeepEqual(before, after):
+	case before == nil:
+		*ops = append(*ops, DiffOperation{Op: "add", Path: path, Value: after})
+	case after == nil:
+		*ops = append(*ops, DiffOperation{Op: "remove", Path: path, Previous: before})
+	default:
+		*ops = append(*ops, DiffOperation{Op: "replace", Path: path, Value: after, Previous: before})
+	}
+}
+
+func diffObjects(path string, before, after map[string]interface{}, ops *[]DiffOperation) {
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := path + "/" + escapePointerToken(key)
+		beforeValue, inBefore := before[key]
+		afterValue, inAfter := after[key]
+		switch {
+		case !inAfter:
+			*ops = append(*ops, DiffOperation{Op: "remove", Path: childPath, Previous: beforeValue})
+		case !inBefore:
+			*ops = append(*ops, DiffOperation{Op: "add", Path: childPath, Value: afterValue})
+		default:
+			diffValues(childPath, beforeValue, afterValue, ops)
+		}
+	}
+}
+
+func diffArrays(path string, before, after []interface{}, ops *[]DiffOperation) {
+	common := len(before)
+	if len(after) < common {
+		common = len(after)
+	}
+	for i := 0; i < common; i++ {
+		diffValues(path+"/"+strconv.Itoa(i), before[i], after[i], ops)
+	}
+	for i := common; i < len(after); i++ {
+		*ops = append(*ops, DiffOperation{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: after[i]})
+	}
+	// Remove from the end so the indices stay valid when applied in order
+	for i := len(before) - 1; i >= common; i-- {
+		*ops = append(*ops, DiffOperation{Op: "remove", Path: path + "/" + strconv.Itoa(i), Previous: before[i]})
+	}
+}
+
+func escapePointerToken(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
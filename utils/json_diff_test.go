@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   []DiffOperation
+	}{
+		{
+			name:   "equal documents",
+			before: `{"a":1,"b":[1,2]}`,
+			after:  `{"a":1,"b":[1,2]}`,
+			want:   []DiffOperation{},
+		},
+		{
+			name:   "created document",
+			before: `null`,
+			after:  `{"a":1}`,
+			want:   []DiffOperation{{Op: "add", Path: "", Value: map[string]interface{}{"a": 1.0}}},
+		},
+		{
+			name:   "deleted document",
+			before: `{"a":1}`,
+			after:  `null`,
+			want:   []DiffOperation{{Op: "remove", Path: "", Previous: map[string]interface{}{"a": 1.0}}},
+		},
+		{
+			name:   "members in key order",
+			before: `{"c":1,"b":2}`,
+			after:  `{"a":3,"b":4}`,
+			want: []DiffOperation{
+				{Op: "add", Path: "/a", Value: 3.0},
+				{Op: "replace", Path: "/b", Value: 4.0, Previous: 2.0},
+				{Op: "remove", Path: "/c", Previous: 1.0},
+			},
+		},
+		{
+			name:   "nested member",
+			before: `{"a":{"b":{"c":1}}}`,
+			after:  `{"a":{"b":{"c":2}}}`,
+			want:   []DiffOperation{{Op: "replace", Path: "/a/b/c", Value: 2.0, Previous: 1.0}},
+		},
+		{
+			name:   "member set to null",
+			before: `{"a":1}`,
+			after:  `{"a":null}`,
+			want:   []DiffOperation{{Op: "remove", Path: "/a", Previous: 1.0}},
+		},
+		{
+			name:   "type change",
+			before: `{"a":[1]}`,
+			after:  `{"a":{"0":1}}`,
+			want: []DiffOperation{{
+				Op:       "replace",
+				Path:     "/a",
+				Value:    map[string]interface{}{"0": 1.0},
+				Previous: []interface{}{1.0},
+			}},
+		},
+		{
+			name:   "array grows",
+			before: `[1]`,
+			after:  `[1,2,3]`,
+			want: []DiffOperation{
+				{Op: "add", Path: "/1", Value: 2.0},
+				{Op: "add", Path: "/2", Value: 3.0},
+			},
+		},
+		{
+			name:   "array shrinks from the end",
+			before: `[1,2,3]`,
+			after:  `[4]`,
+			want: []DiffOperation{
+				{Op: "replace", Path: "/0", Value: 4.0, Previous: 1.0},
+				{Op: "remove", Path: "/2", Previous: 3.0},
+				{Op: "remove", Path: "/1", Previous: 2.0},
+			},
+		},
+		{
+			name:   "escaped member names",
+			before: `{"a/b":1,"c~d":1}`,
+			after:  `{"a/b":2}`,
+			want: []DiffOperation{
+				{Op: "replace", Path: "/a~1b", Value: 2.0, Previous: 1.0},
+				{Op: "remove", Path: "/c~0d", Previous: 1.0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(decodeJSON(t, tt.before), decodeJSON(t, tt.after))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, data string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	return value
+}
+
+// errAny marks cases that only need to fail, whatever the error.
+var errAny = errors.New("any error")
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"adds member", `{"a":1}`, `{"b":2}`, `{"a":1,"b":2}`},
+		{"replaces member", `{"a":1}`, `{"a":"x"}`, `{"a":"x"}`},
+		{"null removes member", `{"a":1,"b":2}`, `{"a":null}`, `{"b":2}`},
+		{"merges nested objects", `{"a":{"b":1,"c":2}}`, `{"a":{"c":null,"d":3}}`, `{"a":{"b":1,"d":3}}`},
+		{"replaces arrays wholesale", `{"a":[1,2,3]}`, `{"a":[4]}`, `{"a":[4]}`},
+		{"non-object patch replaces doc", `{"a":1}`, `[1]`, `[1]`},
+		{"object patch over scalar", `"x"`, `{"a":1}`, `{"a":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ApplyMergePatch(decodeJSON(t, tt.doc), decodeJSON(t, tt.patch))
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		ops     string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "add member",
+			doc:  `{"a":1}`,
+			ops:  `[{"op":"add","path":"/b","value":2}]`,
+			want: `{"a":1,"b":2}`,
+		},
+		{
+			name: "add null value",
+			doc:  `{"a":1}`,
+			ops:  `[{"op":"add","path":"/b","value":null}]`,
+			want: `{"a":1,"b":null}`,
+		},
+		{
+			name: "add inserts into array",
+			doc:  `{"a":[1,3]}`,
+			ops:  `[{"op":"add","path":"/a/1","value":2}]`,
+			want: `{"a":[1,2,3]}`,
+		},
+		{
+			name: "add appends with dash",
+			doc:  `{"a":[1]}`,
+			ops:  `[{"op":"add","path":"/a/-","value":2}]`,
+			want: `{"a":[1,2]}`,
+		},
+		{
+			name: "add replaces root",
+			doc:  `{"a":1}`,
+			ops:  `[{"op":"add","path":"","value":[1]}]`,
+			want: `[1]`,
+		},
+		{
+			name: "remove member",
+			doc:  `{"a":1,"b":2}`,
+			ops:  `[{"op":"remove","path":"/a"}]`,
+			want: `{"b":2}`,
+		},
+		{
+			name: "remove array element",
+			doc:  `{"a":[1,2,3]}`,
+			ops:  `[{"op":"remove","path":"/a/1"}]`,
+			want: `{"a":[1,3]}`,
+		},
+		{
+			name: "replace member with null",
+			doc:  `{"a":1}`,
+			ops:  `[{"op":"replace","path":"/a","value":null}]`,
+			want: `{"a":null}`,
+		},
+		{
+			name: "move member",
+			doc:  `{"a":{"b":1},"c":{}}`,
+			ops:  `[{"op":"move","from":"/a/b","path":"/c/d"}]`,
+			want: `{"a":{},"c":{"d":1}}`,
+		},
+		{
+			name: "copy is independent of source",
+			doc:  `{"a":{"b":1}}`,
+			ops:  `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			want: `{"a":{"b":1},"c":{"b":2}}`,
+		},
+		{
+			name: "test passes",
+			doc:  `{"a":[1,{"b":null}]}`,
+			ops:  `[{"op":"test","path":"/a","value":[1,{"b":null}]}]`,
+			want: `{"a":[1,{"b":null}]}`,
+		},
+		{
+			name: "escaped pointer tokens",
+			doc:  `{"a/b":1,"c~d":2}`,
+			ops:  `[{"op":"remove","path":"/a~1b"},{"op":"replace","path":"/c~0d","value":3}]`,
+			want: `{"c~d":3}`,
+		},
+		{
+			name:    "test fails",
+			doc:     `{"a":1}`,
+			ops:     `[{"op":"test","path":"/a","value":2}]`,
+			wantErr: ErrPatchTestFailed,
+		},
+		{
+			name:    "missing value",
+			doc:     `{"a":1}`,
+			ops:     `[{"op":"add","path":"/b"}]`,
+			wantErr: errAny,
+		},
+		{
+			name:    "replace missing member",
+			doc:     `{"a":1}`,
+			ops:     `[{"op":"replace","path":"/b","value":2}]`,
+			wantErr: errAny,
+		},
+		{
+			name:    "array index out of range",
+			doc:     `{"a":[1]}`,
+			ops:     `[{"op":"add","path":"/a/2","value":2}]`,
+			wantErr: errAny,
+		},
+		{
+			name:    "leading zero index",
+			doc:     `{"a":[1,2]}`,
+			ops:     `[{"op":"remove","path":"/a/01"}]`,
+			wantErr: errAny,
+		},
+		{
+			name:    "move into own child",
+			doc:     `{"a":{"b":{}}}`,
+			ops:     `[{"op":"move","from":"/a","path":"/a/b/c"}]`,
+			wantErr: errAny,
+		},
+		{
+			name:    "pointer without leading slash",
+			doc:     `{"a":1}`,
+			ops:     `[{"op":"remove","path":"a"}]`,
+			wantErr: errAny,
+		},
+		{
+			name:    "unknown op",
+			doc:     `{"a":1}`,
+			ops:     `[{"op":"merge","path":"/a","value":1}]`,
+			wantErr: errAny,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []PatchOperation
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatalf("invalid operations: %v", err)
+			}
+
+			got, err := ApplyJSONPatch(decodeJSON(t, tt.doc), ops)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				if tt.wantErr != errAny && !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPatchOperationHasValue(t *testing.T) {
+	tests := []struct {
+		op   string
+		want bool
+	}{
+		{`{"op":"add","path":"/a","value":1}`, true},
+		{`{"op":"add","path":"/a","value":null}`, true},
+		{`{"op":"add","path":"/a"}`, false},
+	}
+
+	for _, tt := range tests {
+		var op PatchOperation
+		if err := json.Unmarshal([]byte(tt.op), &op); err != nil {
+			t.Fatalf("%s: %v", tt.op, err)
+		}
+		if op.HasValue != tt.want {
+			t.Errorf("%s: HasValue = %v, want %v", tt.op, op.HasValue, tt.want)
+		}
+	}
+}